@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+// AuditResult is the outcome of checking one link, image or iframe
+type AuditResult struct {
+	Kind    string `json:"kind"`
+	URL     string `json:"url"`
+	Status  string `json:"status"`
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type AuditPost struct {
+	ID      int           `json:"id"`
+	Title   string        `json:"title"`
+	Link    string        `json:"link"`
+	Results []AuditResult `json:"results"`
+}
+
+type AuditReport struct {
+	Summary map[string]int `json:"summary"`
+	Posts   []*AuditPost   `json:"posts"`
+}
+
+// Audit statuses
+const (
+	auditOk          = "ok"
+	auditBroken      = "broken"
+	auditNotExported = "not-exported"
+	auditUnchecked   = "unchecked"
+)
+
+// audit checks every link, image and iframe in the posts, writing the
+// results as audit.json and audit.html. It returns the number of problems.
+func audit(posts []Post) int {
+	// Every status is always in the summary, so CI can rely on it
+	report := AuditReport{Summary: map[string]int{
+		auditOk:          0,
+		auditBroken:      0,
+		auditNotExported: 0,
+		auditUnchecked:   0,
+	}}
+	type pending struct {
+		result *AuditResult
+		url    string
+	}
+	checks := []pending{}
+
+	for _, p := range posts {
+		workingOn(LogContext{PostID: p.ID, PostLink: p.Link, Language: p.Language})
+		status("Auditing %s", p.Link)
+		sourceUrl, err := url.Parse(p.Link)
+		if err != nil {
+			warn("Failed to parse post url '%s': %v", p.Link, err)
+			continue
+		}
+		tree, err := html.Parse(strings.NewReader(p.Content.Rendered))
+		if err != nil {
+			warn("Couldn't parse html for %s: %v", p.Link, err)
+			continue
+		}
+		ap := &AuditPost{ID: p.ID, Title: htmlText(p.Title.Rendered), Link: p.Link}
+		for _, ref := range auditRefs(tree) {
+			ref.URL = strings.TrimSpace(ref.URL)
+			ru, err := url.Parse(ref.URL)
+			if err != nil {
+				ap.Results = append(ap.Results, AuditResult{Kind: ref.Kind, URL: ref.URL, Status: auditBroken, Message: err.Error()})
+				continue
+			}
+			ru = sourceUrl.ResolveReference(ru)
+			if ru.Scheme != "http" && ru.Scheme != "https" {
+				continue
+			}
+			ru.Fragment = ""
+			r := AuditResult{Kind: ref.Kind, URL: ru.String()}
+			internal := sameSite(ru) || isShortlink(ru)
+			if t := linkIndex.Lookup(ru); ref.Kind == "link" && internal && t != nil {
+				if t.Exported {
+					r.Status = auditOk
+					r.Message = t.Path
+				} else {
+					r.Status = auditNotExported
+				}
+			} else if !internal && !checkExternal {
+				r.Status = auditUnchecked
+			}
+			ap.Results = append(ap.Results, r)
+		}
+		for i := range ap.Results {
+			if ap.Results[i].Status == "" {
+				checks = append(checks, pending{result: &ap.Results[i], url: ap.Results[i].URL})
+			}
+		}
+		report.Posts = append(report.Posts, ap)
+	}
+	workingOn(LogContext{})
+
+	// Check each distinct url once, several at a time
+	urls := map[string]AuditResult{}
+	distinct := []string{}
+	for _, c := range checks {
+		if _, ok := urls[c.url]; !ok {
+			urls[c.url] = AuditResult{}
+			distinct = append(distinct, c.url)
+		}
+	}
+	work := make(chan string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range work {
+				r := checkUrl(u)
+				mu.Lock()
+				urls[u] = r
+				mu.Unlock()
+			}
+		}()
+	}
+	done := 0
+	for _, u := range distinct {
+		done++
+		status("Checking %d/%d %s", done, len(distinct), u)
+		work <- u
+	}
+	close(work)
+	wg.Wait()
+	endStatus("Checked %d urls", len(urls))
+	for _, c := range checks {
+		r := urls[c.url]
+		c.result.Status = r.Status
+		c.result.Code = r.Code
+		c.result.Message = r.Message
+	}
+
+	problems := 0
+	for _, ap := range report.Posts {
+		sort.SliceStable(ap.Results, func(i, j int) bool {
+			return auditRank(ap.Results[i].Status) < auditRank(ap.Results[j].Status)
+		})
+		for _, r := range ap.Results {
+			report.Summary[r.Status]++
+			if r.Status == auditBroken || r.Status == auditNotExported {
+				problems++
+			}
+		}
+	}
+	sort.SliceStable(report.Posts, func(i, j int) bool {
+		return auditProblems(report.Posts[i]) > auditProblems(report.Posts[j])
+	})
+
+	writeMeta("audit", report)
+	writeAuditHTML(report)
+	info("Audit found %d problems in %d posts", problems, len(report.Posts))
+	return problems
+}
+
+type auditRef struct {
+	Kind string
+	URL  string
+}
+
+// auditRefs finds everything a post links to or embeds
+func auditRefs(node *html.Node) []auditRef {
+	refs := []auditRef{}
+	if node.Type == html.ElementNode {
+		switch node.Data {
+		case "a":
+			if href := getAttr(node, "href"); href != "" && !strings.HasPrefix(href, "#") {
+				refs = append(refs, auditRef{Kind: "link", URL: href})
+			}
+		case "img":
+			if src := getAttr(node, "src"); src != "" {
+				refs = append(refs, auditRef{Kind: "image", URL: src})
+			}
+			for _, part := range strings.Split(getAttr(node, "srcset"), ",") {
+				fields := strings.Fields(part)
+				if len(fields) > 0 {
+					refs = append(refs, auditRef{Kind: "image", URL: fields[0]})
+				}
+			}
+		case "iframe":
+			if src := getAttr(node, "src"); src != "" {
+				refs = append(refs, auditRef{Kind: "iframe", URL: src})
+			}
+		}
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		refs = append(refs, auditRefs(child)...)
+	}
+	return refs
+}
+
+// checkUrl makes a HEAD request, falling back to GET for servers that
+// don't support HEAD
+func checkUrl(u string) AuditResult {
+	resp, err := request(http.MethodHead, u)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		_ = resp.Body.Close()
+		resp, err = request(http.MethodGet, u)
+	}
+	if err != nil {
+		return AuditResult{Status: auditBroken, Message: err.Error()}
+	}
+	_ = resp.Body.Close()
+	r := AuditResult{Code: resp.StatusCode, Status: auditOk}
+	if resp.StatusCode >= 400 {
+		r.Status = auditBroken
+		r.Message = resp.Status
+	}
+	return r
+}
+
+func request(method string, u string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	throttle()
+	return client.Do(req)
+}
+
+func auditRank(status string) int {
+	switch status {
+	case auditBroken:
+		return 0
+	case auditNotExported:
+		return 1
+	case auditUnchecked:
+		return 2
+	}
+	return 3
+}
+
+func auditProblems(ap *AuditPost) int {
+	n := 0
+	for _, r := range ap.Results {
+		if r.Status == auditBroken || r.Status == auditNotExported {
+			n++
+		}
+	}
+	return n
+}
+
+var auditTemplate = htmltemplate.Must(htmltemplate.New("audit").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Link audit</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { cursor: pointer; background: #eee; }
+td.url { word-break: break-all; }
+tr.broken td { background: #fdd; }
+tr.not-exported td { background: #ffd; }
+</style>
+</head>
+<body>
+<h1>Link audit</h1>
+<p>{{range $status, $count := .Summary}}{{$status}}: {{$count}} &nbsp; {{end}}</p>
+<table id="audit">
+<thead><tr><th>Post</th><th>Kind</th><th>URL</th><th>Status</th><th>Code</th><th>Message</th></tr></thead>
+<tbody>
+{{range .Posts}}{{$post := .}}{{range .Results}}<tr class="{{.Status}}"><td><a href="{{$post.Link}}">{{$post.Title}}</a></td><td>{{.Kind}}</td><td class="url"><a href="{{.URL}}">{{.URL}}</a></td><td>{{.Status}}</td><td>{{if .Code}}{{.Code}}{{end}}</td><td>{{.Message}}</td></tr>
+{{end}}{{end}}</tbody>
+</table>
+<script>
+document.querySelectorAll("#audit th").forEach(function (th, col) {
+  var asc = true;
+  th.addEventListener("click", function () {
+    var tbody = document.querySelector("#audit tbody");
+    var rows = Array.from(tbody.rows);
+    rows.sort(function (a, b) {
+      var x = a.cells[col].textContent, y = b.cells[col].textContent;
+      var n = x - y;
+      var c = isNaN(n) ? x.localeCompare(y) : n;
+      return asc ? c : -c;
+    });
+    asc = !asc;
+    rows.forEach(function (r) { tbody.appendChild(r); });
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+func writeAuditHTML(report AuditReport) {
+	filename := filepath.Join(dest, "audit.html")
+	var buff bytes.Buffer
+	err := auditTemplate.Execute(&buff, report)
+	if err != nil {
+		fatal("Failed to render %s: %v", filename, err)
+	}
+	err = writeFile(filename, buff.Bytes())
+	if err != nil {
+		fatal("Failed to write %s: %v", filename, err)
+	}
+}
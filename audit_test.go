@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestAuditRefs(t *testing.T) {
+	tests := []struct {
+		fragment string
+		want     []auditRef
+	}{
+		{`<p>No links</p>`, []auditRef{}},
+		{`<a href="/about/">About</a> <a href="#top">Top</a> <a>Nothing</a>`, []auditRef{
+			{Kind: "link", URL: "/about/"},
+		}},
+		{`<img src="a.jpg" srcset="a-300.jpg 300w, a-1024.jpg 1024w">`, []auditRef{
+			{Kind: "image", URL: "a.jpg"},
+			{Kind: "image", URL: "a-300.jpg"},
+			{Kind: "image", URL: "a-1024.jpg"},
+		}},
+		{`<figure><a href="b.jpg"><img src="b-150.jpg"></a></figure><iframe src="https://video.example/embed/1"></iframe>`, []auditRef{
+			{Kind: "link", URL: "b.jpg"},
+			{Kind: "image", URL: "b-150.jpg"},
+			{Kind: "iframe", URL: "https://video.example/embed/1"},
+		}},
+	}
+	for _, tt := range tests {
+		doc, err := html.Parse(strings.NewReader(tt.fragment))
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", tt.fragment, err)
+		}
+		if got := auditRefs(doc); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("auditRefs(%s) = %v, want %v", tt.fragment, got, tt.want)
+		}
+	}
+}
+
+func TestCheckUrl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+		case "/missing":
+			http.NotFound(w, r)
+		case "/get-only":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		}
+	}))
+	defer server.Close()
+	setFor(t, &client, server.Client())
+	setFor(t, &rateLimit, 0)
+	tests := []struct {
+		path   string
+		status string
+		code   int
+	}{
+		{"/ok", auditOk, 200},
+		{"/missing", auditBroken, 404},
+		{"/get-only", auditOk, 200},
+	}
+	for _, tt := range tests {
+		got := checkUrl(server.URL + tt.path)
+		if got.Status != tt.status || got.Code != tt.code {
+			t.Errorf("checkUrl(%s) = %s %d, want %s %d", tt.path, got.Status, got.Code, tt.status, tt.code)
+		}
+	}
+	if got := checkUrl("http://127.0.0.1:0/"); got.Status != auditBroken || got.Message == "" {
+		t.Errorf("checkUrl of unreachable server = %+v, want broken with a message", got)
+	}
+}
+
+func TestAuditRank(t *testing.T) {
+	order := []string{auditBroken, auditNotExported, auditUnchecked, auditOk}
+	for i := 1; i < len(order); i++ {
+		if auditRank(order[i-1]) >= auditRank(order[i]) {
+			t.Errorf("%s should sort before %s", order[i-1], order[i])
+		}
+	}
+}
+
+func TestAuditProblems(t *testing.T) {
+	tests := []struct {
+		statuses []string
+		want     int
+	}{
+		{nil, 0},
+		{[]string{auditOk, auditUnchecked}, 0},
+		{[]string{auditBroken, auditOk, auditNotExported, auditBroken}, 3},
+	}
+	for _, tt := range tests {
+		ap := &AuditPost{}
+		for _, s := range tt.statuses {
+			ap.Results = append(ap.Results, AuditResult{Status: s})
+		}
+		if got := auditProblems(ap); got != tt.want {
+			t.Errorf("auditProblems(%v) = %d, want %d", tt.statuses, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"html"
+	"maps"
+	"slices"
+)
+
+type AuthorProfile struct {
+	Title     string `yaml:"title" json:"title"`
+	Slug      string `yaml:"slug" json:"slug"`
+	FirstName string `yaml:"firstName,omitempty" json:"firstName,omitempty"`
+	LastName  string `yaml:"lastName,omitempty" json:"lastName,omitempty"`
+	Bio       string `yaml:"bio,omitempty" json:"bio,omitempty"`
+	Website   string `yaml:"website,omitempty" json:"website,omitempty"`
+	Avatar    string `yaml:"avatar,omitempty" json:"avatar,omitempty"`
+	Guest     bool   `yaml:"guest,omitempty" json:"guest,omitempty"`
+	Posts     int    `yaml:"posts" json:"posts"`
+}
+
+// getUserNames fills in the real names of users, which WordPress only
+// shows to authenticated users
+func getUserNames(users map[int]*User) {
+	if auth == "" {
+		return
+	}
+	var result []struct {
+		ID        int
+		FirstName string `mapstructure:"first_name"`
+		LastName  string `mapstructure:"last_name"`
+	}
+	err := fetchRoute("user names", &result, "wp/v2/users?context=edit&_fields=id,first_name,last_name")
+	if err != nil {
+		warn("Failed to fetch names of users: %v", err)
+		return
+	}
+	for _, r := range result {
+		if u, ok := users[r.ID]; ok {
+			u.FirstName = r.FirstName
+			u.LastName = r.LastName
+		}
+	}
+}
+
+// writeAuthors saves a data file describing everyone with a byline on the
+// exported posts, and a landing page for each of them
+func writeAuthors(users map[int]*User, posts []Post) {
+	counts := map[string]int{}
+	bylines := map[string]Byline{}
+	for _, p := range posts {
+		for _, b := range p.Bylines {
+			if _, ok := bylines[b.Slug]; !ok {
+				bylines[b.Slug] = b
+			}
+			counts[b.Slug]++
+		}
+	}
+	profiles := map[string]AuthorProfile{}
+	for _, slug := range slices.Sorted(maps.Keys(bylines)) {
+		b := bylines[slug]
+		if slug == "" {
+			warn("Not saving a profile for %s, they have no slug", b.Name)
+			continue
+		}
+		profile := AuthorProfile{
+			Title:  b.Name,
+			Slug:   slug,
+			Bio:    b.Bio,
+			Avatar: b.Avatar,
+			Guest:  b.Guest,
+			Posts:  counts[slug],
+		}
+		if u, ok := users[b.UserID]; ok && !b.Guest {
+			profile.Title = u.Name
+			profile.FirstName = u.FirstName
+			profile.LastName = u.LastName
+			profile.Bio = u.Description
+			profile.Website = u.URL
+			profile.Avatar = pickAvatar(u.AvatarURLs)
+		}
+		profiles[slug] = profile
+		body := ""
+		if profile.Bio != "" {
+			body = "<p>" + html.EscapeString(profile.Bio) + "</p>"
+		}
+		writeIndexPage("authors", slug, profile, body)
+	}
+	writeMeta("authors", profiles)
+	info("Saved %d authors", len(profiles))
+}
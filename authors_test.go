@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIndexPage(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"generic", "authors/alice/index.md"},
+		{"hugo", "authors/alice/_index.md"},
+		{"jekyll", "_authors/alice.md"},
+		{"gatsby", "authors/alice/index.md"},
+	}
+	for _, tt := range tests {
+		if got := targets[tt.target].IndexPage("authors", "alice"); got != tt.want {
+			t.Errorf("%s IndexPage(authors, alice) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestWriteAuthors(t *testing.T) {
+	setFor(t, &dest, t.TempDir())
+	setFor(t, &target, targets["hugo"])
+	users := map[int]*User{
+		1: {ID: 1, Name: "Alice Smith", Slug: "alice", Description: "Writes things", URL: "https://alice.example/",
+			FirstName: "Alice", LastName: "Smith", AvatarURLs: map[string]string{"96": "a96"}},
+	}
+	posts := []Post{
+		{ID: 5, Bylines: []Byline{{Name: "alice", Slug: "alice", UserID: 1}}},
+		{ID: 6, Bylines: []Byline{{Name: "alice", Slug: "alice", UserID: 1}, {Name: "Guest Bob", Slug: "bob", Guest: true, Bio: "Visiting"}}},
+		{ID: 7, Bylines: []Byline{{Name: "No Slug"}}},
+	}
+	writeAuthors(users, posts)
+
+	data, err := os.ReadFile(filepath.Join(dest, "authors.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]AuthorProfile
+	err = json.Unmarshal(data, &got)
+	if err != nil {
+		t.Fatalf("authors.json isn't json: %v", err)
+	}
+	want := map[string]AuthorProfile{
+		"alice": {Title: "Alice Smith", Slug: "alice", FirstName: "Alice", LastName: "Smith", Bio: "Writes things",
+			Website: "https://alice.example/", Avatar: "a96", Posts: 2},
+		"bob": {Title: "Guest Bob", Slug: "bob", Bio: "Visiting", Guest: true, Posts: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("authors.json = %+v, want %+v", got, want)
+	}
+
+	page, err := os.ReadFile(filepath.Join(dest, "authors", "bob", "_index.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPage := "---\ntitle: Guest Bob\nslug: bob\nbio: Visiting\nguest: true\nposts: 1\n---\n<p>Visiting</p>\n"
+	if string(page) != wantPage {
+		t.Errorf("bob's landing page =\n%s\nwant\n%s", page, wantPage)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Avatars we've already copied, by remote url
+var avatarFiles = map[string]string{}
+
+// localAvatars copies one size of avatar and points every size at it
+func localAvatars(urls map[string]string) map[string]string {
+	if !mirrorAvatars || len(urls) == 0 {
+		return urls
+	}
+	local := copyAvatar(pickAvatar(urls))
+	if local == "" {
+		return urls
+	}
+	ret := map[string]string{}
+	for size := range urls {
+		ret[size] = local
+	}
+	return ret
+}
+
+// pickAvatar chooses the smallest avatar at least as big as --avatar-size,
+// or the biggest there is
+func pickAvatar(urls map[string]string) string {
+	best := -1
+	largest := -1
+	for size := range urls {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			continue
+		}
+		if n >= avatarSize && (best == -1 || n < best) {
+			best = n
+		}
+		if n > largest {
+			largest = n
+		}
+	}
+	if best == -1 {
+		best = largest
+	}
+	return urls[strconv.Itoa(best)]
+}
+
+// copyAvatar saves an avatar as avatars/<hash>, returning the url of the
+// local copy, or "" if we couldn't fetch it
+func copyAvatar(u string) string {
+	if u == "" {
+		return ""
+	}
+	if local, ok := avatarFiles[u]; ok {
+		return local
+	}
+	avatarFiles[u] = ""
+	assetsBar.AddTotal(1)
+	defer assetsBar.Add(1)
+
+	// The same person at any size should get the same hash
+	key := u
+	au, err := url.Parse(u)
+	if err == nil {
+		q := au.Query()
+		q.Del("s")
+		q.Del("size")
+		au.RawQuery = q.Encode()
+		key = au.String()
+	}
+
+	resp, err := get(u)
+	if err != nil {
+		warn("Failed to get avatar %s: %v", u, err)
+		return ""
+	}
+	if resp.StatusCode != 200 {
+		errorList.Missing = append(errorList.Missing, Missing{
+			Page:   logContext.PostLink,
+			URL:    u,
+			Status: resp.Status,
+		})
+		return ""
+	}
+	suffix := ".jpg"
+	justType, _, _ := mime.ParseMediaType(resp.ContentType)
+	if justType != "image/jpeg" {
+		suffixes, err := mime.ExtensionsByType(justType)
+		if err == nil && len(suffixes) > 0 {
+			suffix = suffixes[0]
+		}
+	}
+	filename := md5Hex(key) + suffix
+	dir := filepath.Join(dest, "avatars")
+	_ = os.MkdirAll(dir, 0755)
+	err = writeFile(filepath.Join(dir, filename), resp.BodyContent)
+	if err != nil {
+		postFailed("Failed to write avatar %s: %v", filename, err)
+		return ""
+	}
+	assetsBar.AddBytes(int64(len(resp.BodyContent)))
+	avatarFiles[u] = sitePath + "/avatars/" + filename
+	return avatarFiles[u]
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPickAvatar(t *testing.T) {
+	urls := map[string]string{"24": "a24", "48": "a48", "96": "a96", "bad": "abad"}
+	tests := []struct {
+		size int
+		want string
+	}{
+		{0, "a24"},
+		{24, "a24"},
+		{40, "a48"},
+		{96, "a96"},
+		{200, "a96"},
+	}
+	for _, tt := range tests {
+		setFor(t, &avatarSize, tt.size)
+		if got := pickAvatar(urls); got != tt.want {
+			t.Errorf("pickAvatar at size %d = %q, want %q", tt.size, got, tt.want)
+		}
+	}
+	if got := pickAvatar(nil); got != "" {
+		t.Errorf("pickAvatar(nil) = %q, want nothing", got)
+	}
+}
+
+func TestLocalAvatars(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("png"))
+	}))
+	defer server.Close()
+	setFor(t, &client, server.Client())
+	setFor(t, &cacheDir, "")
+	setFor(t, &rateLimit, 0)
+	setFor(t, &dest, t.TempDir())
+	setFor(t, &sitePath, "/blog")
+	setFor(t, &avatarSize, 48)
+	setFor(t, &avatarFiles, map[string]string{})
+	setFor(t, &errorList, Errors{})
+
+	setFor(t, &mirrorAvatars, false)
+	urls := map[string]string{"48": server.URL + "/avatar/abc?s=48", "96": server.URL + "/avatar/abc?s=96"}
+	if got := localAvatars(urls); got["48"] != urls["48"] {
+		t.Errorf("localAvatars without --mirror-avatars = %v, want them unchanged", got)
+	}
+
+	mirrorAvatars = true
+	got := localAvatars(urls)
+	local := "/blog/avatars/" + md5Hex(server.URL+"/avatar/abc") + ".png"
+	if got["48"] != local || got["96"] != local {
+		t.Errorf("localAvatars = %v, want every size at %s", got, local)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "avatars", filepath.Base(local))); err != nil {
+		t.Errorf("avatar wasn't saved: %v", err)
+	}
+
+	missing := map[string]string{"48": server.URL + "/missing"}
+	if got := localAvatars(missing); got["48"] != missing["48"] {
+		t.Errorf("localAvatars of a missing avatar = %v, want it unchanged", got)
+	}
+	if len(errorList.Missing) != 1 {
+		t.Errorf("missing avatar reported %d times, want once", len(errorList.Missing))
+	}
+}
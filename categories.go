@@ -0,0 +1,78 @@
+package main
+
+import (
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+type CategoryPage struct {
+	Title       string   `yaml:"title"`
+	Slug        string   `yaml:"slug"`
+	Path        string   `yaml:"path"`
+	Parent      string   `yaml:"parent,omitempty"`
+	Breadcrumbs []string `yaml:"breadcrumbs,omitempty"`
+	Count       int      `yaml:"count"`
+}
+
+// categoryPath is the slugs of a category and its ancestors, like news/local/sports
+func categoryPath(categories map[int]*Category, id int) string {
+	slugs := []string{}
+	for _, c := range termChain(categories, id, func(c *Category) int { return c.Parent }) {
+		slugs = append(slugs, c.Slug)
+	}
+	return strings.Join(slugs, "/")
+}
+
+func categoryPaths(ids []int, categories map[int]*Category) []string {
+	ret := []string{}
+	for _, id := range ids {
+		if _, ok := categories[id]; ok {
+			ret = append(ret, categoryPath(categories, id))
+		}
+	}
+	return ret
+}
+
+// writeCategories saves a landing page for each category, with its
+// description as the body
+func writeCategories(categories map[int]*Category) {
+	for _, id := range slices.Sorted(maps.Keys(categories)) {
+		c := categories[id]
+		page := CategoryPage{
+			Title: c.Name,
+			Slug:  c.Slug,
+			Path:  categoryPath(categories, id),
+			Count: c.Count,
+		}
+		if parent, ok := categories[c.Parent]; ok {
+			page.Parent = parent.Slug
+		}
+		for _, ancestor := range termChain(categories, id, func(c *Category) int { return c.Parent }) {
+			page.Breadcrumbs = append(page.Breadcrumbs, ancestor.Name)
+		}
+
+		workingOn(LogContext{PostLink: c.Link})
+		body := ""
+		if strings.TrimSpace(c.Description) != "" {
+			sourceUrl, err := url.Parse(c.Link)
+			if err != nil || !sourceUrl.IsAbs() {
+				sourceUrl, _ = url.Parse(apiUrl)
+			}
+			dir := filepath.Dir(filepath.Join(dest, filepath.FromSlash(target.IndexPage("categories", page.Path))))
+			// Images in the description are copied alongside the page
+			err = os.MkdirAll(dir, 0755)
+			if err != nil {
+				postFailed("Failed to create directory %s: %v", dir, err)
+				continue
+			}
+			body = rewriteHTML(c.Link, c.Description, dir, sourceUrl, termUrlPath("categories", page.Path))
+		}
+		writeIndexPage("categories", page.Path, page, body)
+	}
+	workingOn(LogContext{})
+	info("Saved %d categories", len(categories))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testCategories = map[int]*Category{
+	1: {ID: 1, Name: "News", Slug: "news"},
+	2: {ID: 2, Name: "Local", Slug: "local", Parent: 1},
+	3: {ID: 3, Name: "Sports", Slug: "sports", Parent: 2},
+	4: {ID: 4, Name: "Recipes", Slug: "recipes"},
+}
+
+func TestCategoryPath(t *testing.T) {
+	tests := []struct {
+		id   int
+		want string
+	}{
+		{1, "news"},
+		{2, "news/local"},
+		{3, "news/local/sports"},
+		{4, "recipes"},
+		{99, ""},
+	}
+	for _, tt := range tests {
+		if got := categoryPath(testCategories, tt.id); got != tt.want {
+			t.Errorf("categoryPath(%d) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestCategoryPaths(t *testing.T) {
+	tests := []struct {
+		ids  []int
+		want []string
+	}{
+		{nil, []string{}},
+		{[]int{3, 4}, []string{"news/local/sports", "recipes"}},
+		{[]int{99, 2}, []string{"news/local"}},
+	}
+	for _, tt := range tests {
+		if got := categoryPaths(tt.ids, testCategories); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("categoryPaths(%v) = %q, want %q", tt.ids, got, tt.want)
+		}
+	}
+}
+
+func TestWriteCategories(t *testing.T) {
+	testApi(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("png"))
+	})
+	site := strings.TrimSuffix(apiUrl, "/wp-json/")
+	setFor(t, &dest, t.TempDir())
+	setFor(t, &target, targets["hugo"])
+	setFor(t, &sitePath, "")
+	setFor(t, &linkStyle, "none")
+	categories := map[int]*Category{
+		1: {ID: 1, Name: "News", Slug: "news", Count: 3, Link: site + "/category/news/"},
+		2: {ID: 2, Name: "Local", Slug: "local", Parent: 1, Count: 2, Link: site + "/category/news/local/",
+			Description: `<p>Around town <img src="` + site + `/wp-content/uploads/town.png"></p>`},
+	}
+	writeCategories(categories)
+
+	page, err := os.ReadFile(filepath.Join(dest, "categories", "news", "local", "_index.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "---\ntitle: Local\nslug: local\npath: news/local\nparent: news\nbreadcrumbs:\n- News\n- Local\ncount: 2\n---\n"
+	if !strings.HasPrefix(string(page), want) {
+		t.Errorf("landing page for news/local =\n%s\nwant it to start\n%s", page, want)
+	}
+	if !strings.Contains(string(page), "<p>Around town <img") {
+		t.Errorf("landing page for news/local is missing its description:\n%s", page)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "categories", "news", "local", "town.png")); err != nil {
+		t.Errorf("image in the description wasn't copied: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "categories", "news", "_index.md")); err != nil {
+		t.Errorf("landing page for news wasn't saved: %v", err)
+	}
+}
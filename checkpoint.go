@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+
+	flag "github.com/spf13/pflag"
+)
+
+// Checkpoint remembers how far through an export we've got, so that we can
+// pick up where we left off with --resume. Listing pages are saved a file
+// each, and posts and assets are appended to a journal as we finish them.
+type Checkpoint struct {
+	dir     string
+	journal *os.File
+	mu      sync.Mutex
+	posts   map[string]bool
+}
+
+// CheckpointEntry is a line of the journal
+type CheckpointEntry struct {
+	Site  string `json:"site,omitempty"`
+	Post  int    `json:"post,omitempty"`
+	Asset string `json:"asset,omitempty"`
+	File  string `json:"file,omitempty"`
+}
+
+var checkpoint *Checkpoint
+
+// openCheckpoint starts a checkpoint in dir, carrying on from the one that's
+// there if we're resuming
+func openCheckpoint(dir string) *Checkpoint {
+	c := &Checkpoint{dir: dir, posts: map[string]bool{}}
+	if !resume {
+		_ = os.RemoveAll(dir)
+	} else if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		info("There's no checkpoint in %s, starting from the beginning", dir)
+	} else if c.sameFlags() {
+		c.load()
+	} else {
+		_ = os.RemoveAll(dir)
+	}
+	err := os.MkdirAll(filepath.Join(dir, "pages"), 0755)
+	if err != nil {
+		fatal("Failed to create checkpoint %s: %v", dir, err)
+	}
+	c.keepSalt()
+	data, err := json.MarshalIndent(exportFlags(), "", "  ")
+	if err == nil {
+		err = writeFile(filepath.Join(dir, "flags.json"), data)
+	}
+	if err != nil {
+		fatal("Failed to write checkpoint: %v", err)
+	}
+	c.journal, err = os.OpenFile(filepath.Join(dir, "journal"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		fatal("Failed to open checkpoint %s: %v", dir, err)
+	}
+	return c
+}
+
+// Flags that don't change what we save, so can be different when resuming.
+// We leave out --auth so as not to save the password, and the salt is
+// checked on its own.
+var resumableFlags = []string{"anonymous-salt", "auth", "cache", "concurrency", "keep-going", "log", "log-format", "only-ids",
+	"quiet", "rate", "resume", "silent", "stale", "user-agent"}
+
+// exportFlags is the flags, and the site, that decide what we save
+func exportFlags() map[string]string {
+	flags := map[string]string{"site": strings.Join(flag.Args(), " ")}
+	flag.VisitAll(func(f *flag.Flag) {
+		if !slices.Contains(resumableFlags, f.Name) {
+			flags["--"+f.Name] = f.Value.String()
+		}
+	})
+	return flags
+}
+
+// sameFlags checks that we're resuming the export we were interrupted in,
+// as carrying on with different flags would leave a mix of the two
+func (c *Checkpoint) sameFlags() bool {
+	data, err := os.ReadFile(filepath.Join(c.dir, "flags.json"))
+	if err != nil {
+		// Interrupted before we'd saved anything
+		info("There's no checkpoint in %s, starting from the beginning", c.dir)
+		return false
+	}
+	saved := map[string]string{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		fatal("Failed to read checkpoint: %v", err)
+	}
+	flags := exportFlags()
+	for _, name := range slices.Sorted(maps.Keys(flags)) {
+		if saved[name] != flags[name] {
+			fatal("The export in %s was run with %s '%s' rather than '%s', run with the same flags to resume it or without --resume to start again",
+				dest, name, saved[name], flags[name])
+		}
+	}
+	return true
+}
+
+// keepSalt gives anonymous commenters the IDs they had before we were
+// interrupted
+func (c *Checkpoint) keepSalt() {
+	filename := filepath.Join(c.dir, "salt")
+	data, err := os.ReadFile(filename)
+	if err == nil {
+		saved := string(data)
+		if flag.CommandLine.Changed("anonymous-salt") && saved != anonymousSalt {
+			fatal("The export in %s was run with a different --anonymous-salt, run with the same one to resume it or without --resume to start again", dest)
+		}
+		anonymousSalt = saved
+		return
+	}
+	err = writeFile(filename, []byte(anonymousSalt))
+	if err != nil {
+		fatal("Failed to write checkpoint: %v", err)
+	}
+}
+
+func (c *Checkpoint) load() {
+	f, err := os.Open(filepath.Join(c.dir, "journal"))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			warn("Failed to read checkpoint: %v", err)
+		}
+		return
+	}
+	defer f.Close()
+	assets := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var e CheckpointEntry
+		// A line cut short when we were killed is just something to do again
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		switch {
+		case e.Post != 0:
+			c.posts[postKey(e.Site, e.Post)] = true
+		case e.Asset != "":
+			fetchedAssets[e.Asset] = e.File
+			assets++
+		}
+	}
+	info("Resuming with %d posts and %d assets already saved", len(c.posts), assets)
+}
+
+func postKey(site string, id int) string {
+	return fmt.Sprintf("%s/%d", site, id)
+}
+
+func (c *Checkpoint) write(e CheckpointEntry) {
+	if c == nil {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		fatal("Failed to encode checkpoint: %v", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = c.journal.Write(append(data, '\n'))
+	if err != nil {
+		fatal("Failed to write checkpoint: %v", err)
+	}
+}
+
+// postDone is whether we saved a post before being interrupted
+func (c *Checkpoint) postDone(p Post) bool {
+	return c != nil && c.posts[postKey(sitePath, p.ID)]
+}
+
+func (c *Checkpoint) savePost(p Post) {
+	c.write(CheckpointEntry{Site: sitePath, Post: p.ID})
+}
+
+// saveAsset records an asset we've copied, by its key in fetchedAssets
+func (c *Checkpoint) saveAsset(key string, filename string) {
+	c.write(CheckpointEntry{Asset: key, File: filename})
+}
+
+func (c *Checkpoint) pageFile(u string) string {
+	return filepath.Join(c.dir, "pages", fmt.Sprintf("%16x", md5.Sum([]byte(u))))
+}
+
+// page is a page of a listing we fetched before being interrupted
+func (c *Checkpoint) page(u string) (Response, bool) {
+	if c == nil {
+		return Response{}, false
+	}
+	r, err := loadResponse(c.pageFile(u))
+	if err != nil {
+		return Response{}, false
+	}
+	return r, true
+}
+
+func (c *Checkpoint) savePage(u string, r Response) {
+	if c == nil {
+		return
+	}
+	err := saveResponse(c.pageFile(u), r)
+	if err != nil {
+		fatal("Failed to write checkpoint: %v", err)
+	}
+}
+
+// finish throws the checkpoint away, as there's nothing left to resume
+func (c *Checkpoint) finish() {
+	if c == nil {
+		return
+	}
+	_ = c.journal.Close()
+	_ = os.RemoveAll(c.dir)
+}
+
+// The temporary files we're writing, to tidy up if we're interrupted
+var tempFiles = struct {
+	sync.Mutex
+	names map[string]bool
+}{names: map[string]bool{}}
+
+func addTempFile(name string) {
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+	tempFiles.names[name] = true
+}
+
+// createTemp makes a temporary file for filename, holding the lock until it's
+// in the list so that an interrupt can't come between the two
+func createTemp(filename string) (*os.File, error) {
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	tempFiles.names[f.Name()] = true
+	return f, nil
+}
+
+func removeTempFile(name string) {
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+	delete(tempFiles.names, name)
+}
+
+// writeFile writes a file by way of a temporary file alongside it, so that
+// we never leave half a file behind if we're interrupted
+func writeFile(filename string, data []byte) error {
+	_, err := copyToFile(filename, bytes.NewReader(data))
+	return err
+}
+
+// copyToFile is writeFile for a stream, returning how much it wrote
+func copyToFile(filename string, r io.Reader) (int64, error) {
+	f, err := createTemp(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer removeTempFile(f.Name())
+	n, err := io.Copy(f, r)
+	if err == nil {
+		// Temporary files are only readable by us
+		err = f.Chmod(0644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return n, err
+	}
+	return n, nil
+}
+
+// handleInterrupts stops cleanly on SIGINT or SIGTERM, leaving the
+// checkpoint for --resume and no half-written files
+func handleInterrupts() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		// We keep hold of the lock, so that nobody starts another file
+		tempFiles.Lock()
+		for name := range tempFiles.names {
+			_ = os.Remove(name)
+		}
+		outputMu.Lock()
+		progress.clearLocked()
+		logEventLocked(slog.LevelWarn, "interrupted", slog.String("signal", sig.String()))
+		if checkpoint != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Interrupted, run again with --resume to carry on from here\n")
+		}
+		code := 1
+		if s, ok := sig.(syscall.Signal); ok {
+			code = 128 + int(s)
+		}
+		os.Exit(code)
+	}()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "index.md")
+	for _, content := range []string{"first", "second"} {
+		err := writeFile(filename, []byte(content))
+		if err != nil {
+			t.Fatalf("writeFile failed: %v", err)
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil || string(data) != content {
+			t.Errorf("after writeFile(%q) file holds %q, %v", content, data, err)
+		}
+	}
+	fi, err := os.Stat(filename)
+	if err != nil || fi.Mode().Perm() != 0644 {
+		t.Errorf("writeFile left a file with mode %v, %v, want 0644", fi.Mode().Perm(), err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("writeFile left %d files behind, want just the one", len(entries))
+	}
+	tempFiles.Lock()
+	left := len(tempFiles.names)
+	tempFiles.Unlock()
+	if left != 0 {
+		t.Errorf("%d temporary files still registered", left)
+	}
+	if err := writeFile(filepath.Join(dir, "missing", "index.md"), []byte("x")); err == nil {
+		t.Errorf("writeFile into a missing directory succeeded, want an error")
+	}
+}
+
+func TestCreateTemp(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "index.md")
+	f, err := createTemp(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tempFiles.Lock()
+	registered := tempFiles.names[f.Name()]
+	tempFiles.Unlock()
+	if !registered {
+		t.Errorf("createTemp didn't register %s to tidy up", f.Name())
+	}
+	if filepath.Dir(f.Name()) != filepath.Dir(filename) {
+		t.Errorf("createTemp made %s, want it alongside %s", f.Name(), filename)
+	}
+	removeTempFile(f.Name())
+}
+
+func TestCopyToFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "photo.jpg")
+	n, err := copyToFile(filename, bytes.NewReader([]byte("jpeg data")))
+	if err != nil || n != 9 {
+		t.Errorf("copyToFile = %d, %v, want 9 bytes", n, err)
+	}
+}
+
+func TestExportFlags(t *testing.T) {
+	flags := exportFlags()
+	for _, name := range resumableFlags {
+		if _, ok := flags["--"+name]; ok {
+			t.Errorf("exportFlags includes --%s, which we can resume with a different value", name)
+		}
+	}
+	for _, name := range []string{"site", "--output", "--postfile", "--pingbacks"} {
+		if _, ok := flags[name]; !ok {
+			t.Errorf("exportFlags is missing %s", name)
+		}
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), ".checkpoint")
+	setFor(t, &dest, filepath.Dir(dir))
+	setFor(t, &sitePath, "/chemistry")
+	setFor(t, &fetchedAssets, map[string]string{})
+	setFor(t, &anonymousSalt, "first salt")
+	setFor(t, &resume, false)
+
+	c := openCheckpoint(dir)
+	c.savePost(Post{ID: 5})
+	c.saveAsset("out/post\x00https://example.com/a.jpg", "a.jpg")
+	c.savePage("https://example.com/wp-json/wp/v2/posts?page=1", Response{StatusCode: 200, BodyContent: []byte("[]")})
+	_, _ = c.journal.WriteString(`{"post":`)
+	_ = c.journal.Close()
+
+	// A new run picks a new salt, but resuming keeps the old one
+	anonymousSalt = "second salt"
+	resume = true
+	fetchedAssets = map[string]string{}
+	c = openCheckpoint(dir)
+	defer c.journal.Close()
+	if !c.postDone(Post{ID: 5}) || c.postDone(Post{ID: 6}) {
+		t.Errorf("resumed checkpoint has posts %v, want just 5", c.posts)
+	}
+	sitePath = ""
+	if c.postDone(Post{ID: 5}) {
+		t.Errorf("post 5 of /chemistry counts as post 5 of the main site")
+	}
+	if fetchedAssets["out/post\x00https://example.com/a.jpg"] != "a.jpg" {
+		t.Errorf("resumed checkpoint has assets %v, want a.jpg", fetchedAssets)
+	}
+	if r, ok := c.page("https://example.com/wp-json/wp/v2/posts?page=1"); !ok || string(r.BodyContent) != "[]" {
+		t.Errorf("resumed checkpoint lost the first page of posts")
+	}
+	if _, ok := c.page("https://example.com/wp-json/wp/v2/posts?page=2"); ok {
+		t.Errorf("resumed checkpoint has a page we never fetched")
+	}
+	if anonymousSalt != "first salt" {
+		t.Errorf("resumed with salt %q, want the one we started with", anonymousSalt)
+	}
+
+	c.finish()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("finished checkpoint is still there: %v", err)
+	}
+}
+
+func TestNilCheckpoint(t *testing.T) {
+	var c *Checkpoint
+	c.savePost(Post{ID: 5})
+	c.saveAsset("key", "a.jpg")
+	c.savePage("https://example.com/", Response{})
+	c.finish()
+	if c.postDone(Post{ID: 5}) {
+		t.Errorf("nil checkpoint has a post done")
+	}
+	if _, ok := c.page("https://example.com/"); ok {
+		t.Errorf("nil checkpoint has a page")
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Byline is one of the authors of a post, who may be a WordPress user or
+// a guest author who only exists in a co-author plugin
+type Byline struct {
+	Name   string
+	Slug   string
+	UserID int
+	Guest  bool
+	Bio    string
+	Avatar string
+}
+
+// resolveBylines finds the authors of each post from the fields added by
+// Co-Authors Plus or PublishPress Authors, falling back to the post author
+func resolveBylines(posts []Post, users map[int]*User) {
+	userBySlug := map[string]*User{}
+	for _, u := range users {
+		userBySlug[u.Slug] = u
+	}
+	terms := authorTerms(posts)
+	capTerms := coauthorTerms(posts, userBySlug)
+	for i := range posts {
+		p := &posts[i]
+		bylines := []Byline{}
+		for _, raw := range append(p.PPAuthors, p.Coauthors...) {
+			// Co-Authors Plus gives the IDs of terms in its author taxonomy
+			if id, ok := raw.(float64); ok {
+				if b, ok := capTerms[int(id)]; ok {
+					bylines = append(bylines, b)
+					continue
+				}
+			}
+			if b, ok := pluginByline(raw, users); ok {
+				bylines = append(bylines, b)
+			}
+		}
+		if len(bylines) == 0 {
+			for _, id := range p.PPMAAuthor {
+				if b, ok := terms[id]; ok {
+					bylines = append(bylines, b)
+				}
+			}
+		}
+		for j := range bylines {
+			b := &bylines[j]
+			if u, ok := userBySlug[b.Slug]; ok && b.UserID == 0 && !b.Guest {
+				b.UserID = u.ID
+			}
+			if b.UserID == 0 {
+				b.Guest = true
+			}
+		}
+		if len(bylines) == 0 {
+			if u, ok := users[p.Author]; ok {
+				bylines = append(bylines, Byline{Name: u.Name, Slug: u.Slug, UserID: u.ID})
+			}
+		}
+		p.Bylines = dedupeBylines(bylines)
+	}
+}
+
+func dedupeBylines(bylines []Byline) []Byline {
+	seen := map[string]bool{}
+	ret := []Byline{}
+	for _, b := range bylines {
+		key := b.Slug + "\x00" + b.Name
+		if !seen[key] {
+			seen[key] = true
+			ret = append(ret, b)
+		}
+	}
+	return ret
+}
+
+// pluginByline reads an author from the coauthors field of Co-Authors Plus
+// or the authors field of PublishPress Authors
+func pluginByline(raw interface{}, users map[int]*User) (Byline, bool) {
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		str := func(keys ...string) string {
+			for _, k := range keys {
+				if s, ok := v[k]; ok && s != nil {
+					if ret := strings.TrimSpace(fmt.Sprint(s)); ret != "" {
+						return ret
+					}
+				}
+			}
+			return ""
+		}
+		b := Byline{
+			Name:   str("display_name", "name"),
+			Slug:   str("slug", "user_nicename"),
+			Bio:    str("description"),
+			Avatar: str("avatar_url"),
+		}
+		b.UserID, _ = strconv.Atoi(str("user_id", "ID", "id"))
+		switch str("is_guest", "type") {
+		case "true", "1", "guest-author":
+			b.Guest = true
+			b.UserID = 0
+		}
+		if b.Name == "" {
+			return b, false
+		}
+		return b, true
+	case float64:
+		// just a user ID
+		if u, ok := users[int(v)]; ok {
+			return Byline{Name: u.Name, Slug: u.Slug, UserID: u.ID}, true
+		}
+	}
+	return Byline{}, false
+}
+
+// authorTerms fetches the terms of the PublishPress Authors taxonomy, if
+// any of the posts use it
+func authorTerms(posts []Post) map[int]Byline {
+	ret := map[int]Byline{}
+	used := false
+	for _, p := range posts {
+		if len(p.PPMAAuthor) > 0 {
+			used = true
+			break
+		}
+	}
+	if !used {
+		return ret
+	}
+	var terms []Term
+	err := fetchRoute("authors", &terms, "wp/v2/ppma_author?context=view&_fields=id,name,slug,description")
+	if err != nil {
+		warn("Failed to fetch co-authors: %v", err)
+		return ret
+	}
+	for _, t := range terms {
+		ret[t.ID] = Byline{Name: t.Name, Slug: t.Slug, Bio: t.Description}
+	}
+	return ret
+}
+
+// coauthorTerms fetches the terms of the Co-Authors Plus author taxonomy,
+// if any of the posts list their co-authors by term ID. The taxonomy is
+// author, but some versions give it the rest base coauthors.
+func coauthorTerms(posts []Post, userBySlug map[string]*User) map[int]Byline {
+	ret := map[int]Byline{}
+	used := false
+	for _, p := range posts {
+		for _, raw := range p.Coauthors {
+			if _, ok := raw.(float64); ok {
+				used = true
+			}
+		}
+	}
+	if !used {
+		return ret
+	}
+	var terms []Term
+	const fields = "?context=view&_fields=id,name,slug,description"
+	err := fetchRoute("co-authors", &terms, "wp/v2/author"+fields)
+	if err != nil {
+		terms = nil
+		err = fetchRoute("co-authors", &terms, "wp/v2/coauthors"+fields)
+	}
+	if err != nil {
+		warn("Failed to fetch co-authors: %v", err)
+		return ret
+	}
+	for _, t := range terms {
+		// Co-Authors Plus prefixes the slug of each author with cap-, and
+		// names the terms of users by their login
+		b := Byline{Name: t.Name, Slug: strings.TrimPrefix(t.Slug, "cap-")}
+		if u, ok := userBySlug[b.Slug]; ok {
+			b.Name = u.Name
+			b.UserID = u.ID
+		}
+		ret[t.ID] = b
+	}
+	return ret
+}
+
+func bylineNames(bylines []Byline) []string {
+	ret := []string{}
+	for _, b := range bylines {
+		ret = append(ret, b.Name)
+	}
+	return ret
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestPluginByline(t *testing.T) {
+	users := map[int]*User{3: {ID: 3, Name: "Carol", Slug: "carol"}}
+	tests := []struct {
+		raw  interface{}
+		want Byline
+		ok   bool
+	}{
+		{map[string]interface{}{"display_name": "Alice", "user_nicename": "alice", "ID": "1"},
+			Byline{Name: "Alice", Slug: "alice", UserID: 1}, true},
+		{map[string]interface{}{"name": "Bob", "slug": "bob", "user_id": float64(2), "description": "Bio", "avatar_url": "b.jpg"},
+			Byline{Name: "Bob", Slug: "bob", UserID: 2, Bio: "Bio", Avatar: "b.jpg"}, true},
+		{map[string]interface{}{"display_name": "Guest", "slug": "guest", "id": float64(9), "is_guest": true},
+			Byline{Name: "Guest", Slug: "guest", Guest: true}, true},
+		{map[string]interface{}{"display_name": "Guest", "slug": "guest", "type": "guest-author"},
+			Byline{Name: "Guest", Slug: "guest", Guest: true}, true},
+		{map[string]interface{}{"display_name": "  ", "slug": "nobody"}, Byline{}, false},
+		{float64(3), Byline{Name: "Carol", Slug: "carol", UserID: 3}, true},
+		{float64(4), Byline{}, false},
+		{"alice", Byline{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := pluginByline(tt.raw, users)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("pluginByline(%v) = %+v, %v, want %+v, %v", tt.raw, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDedupeBylines(t *testing.T) {
+	bylines := []Byline{{Name: "Alice", Slug: "alice"}, {Name: "Bob", Slug: "bob"}, {Name: "Alice", Slug: "alice", UserID: 1}}
+	want := []Byline{{Name: "Alice", Slug: "alice"}, {Name: "Bob", Slug: "bob"}}
+	if got := dedupeBylines(bylines); !reflect.DeepEqual(got, want) {
+		t.Errorf("dedupeBylines = %+v, want %+v", got, want)
+	}
+}
+
+func TestResolveBylines(t *testing.T) {
+	// Co-Authors Plus terms are only under the coauthors rest base here
+	testApi(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/wp-json/wp/v2/coauthors":
+			_ = json.NewEncoder(w).Encode([]map[string]any{
+				{"id": 20, "name": "alice", "slug": "cap-alice"},
+				{"id": 21, "name": "Guest Dan", "slug": "cap-dan"},
+			})
+		case "/wp-json/wp/v2/ppma_author":
+			_ = json.NewEncoder(w).Encode([]map[string]any{
+				{"id": 30, "name": "Erin", "slug": "erin", "description": "Erin's bio"},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	users := map[int]*User{
+		1: {ID: 1, Name: "Alice", Slug: "alice"},
+		2: {ID: 2, Name: "Bob", Slug: "bob"},
+	}
+	posts := []Post{
+		{ID: 5, Author: 2},
+		{ID: 6, Author: 2, Coauthors: []interface{}{float64(20), float64(21), float64(20)}},
+		{ID: 7, Author: 2, PPMAAuthor: []int{30}},
+		{ID: 8, Author: 1, PPAuthors: []interface{}{map[string]interface{}{"display_name": "Bob", "slug": "bob"}}},
+	}
+	resolveBylines(posts, users)
+	want := map[int][]Byline{
+		5: {{Name: "Bob", Slug: "bob", UserID: 2}},
+		6: {{Name: "Alice", Slug: "alice", UserID: 1}, {Name: "Guest Dan", Slug: "dan", Guest: true}},
+		7: {{Name: "Erin", Slug: "erin", Guest: true, Bio: "Erin's bio"}},
+		8: {{Name: "Bob", Slug: "bob", UserID: 2}},
+	}
+	for _, p := range posts {
+		if !reflect.DeepEqual(p.Bylines, want[p.ID]) {
+			t.Errorf("bylines of post %d = %+v, want %+v", p.ID, p.Bylines, want[p.ID])
+		}
+	}
+}
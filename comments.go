@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	_ "modernc.org/sqlite"
+)
+
+var commentFormats = []string{"json", "staticman", "isso", "remark42", "disqus"}
+
+// CommentThread is all the comments on one post, for the formats that
+// write every comment on the site into one file
+type CommentThread struct {
+	Post     Post
+	Comments []Comment
+}
+
+var commentThreads []CommentThread
+
+// saveComments writes the comments for one post, or saves them to write
+// later for formats that have a single file for the whole site. Pingbacks
+// and comments that aren't approved always go in their own json files.
+func saveComments(p Post, pc *PostComments) {
+	outputDir, _ := postOutput(p)
+	prepare := func(comments []Comment) []Comment {
+		comments = append([]Comment{}, comments...)
+		// Parse each date once, rather than on every comparison
+		times := make(map[int]time.Time, len(comments))
+		for _, c := range comments {
+			times[c.ID] = commentTime(c)
+		}
+		sort.SliceStable(comments, func(i, j int) bool {
+			return times[comments[i].ID].Before(times[comments[j].ID])
+		})
+		for i := range comments {
+			fixCommentBody(&comments[i], p, outputDir)
+		}
+		return comments
+	}
+
+	if len(pc.Pings) > 0 {
+		writeCommentsJSON(filepath.Join(outputDir, languageFilename(p.Language, "pingbacks.json")), prepare(pc.Pings))
+	}
+	for _, status := range moderationStatuses {
+		if len(pc.Status[status]) > 0 {
+			writeCommentsJSON(filepath.Join(outputDir, languageFilename(p.Language, "comments-"+status+".json")), prepare(pc.Status[status]))
+		}
+	}
+	if len(pc.Approved) == 0 {
+		return
+	}
+	comments := prepare(pc.Approved)
+
+	switch commentFormat {
+	case "json":
+		writeCommentsJSON(filepath.Join(outputDir, languageFilename(p.Language, "comments.json")), comments)
+	case "staticman":
+		writeStaticmanComments(p, comments)
+	default:
+		commentThreads = append(commentThreads, CommentThread{Post: p, Comments: comments})
+	}
+}
+
+func writeCommentsJSON(filename string, comments []Comment) {
+	var buff bytes.Buffer
+	enc := json.NewEncoder(&buff)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(comments)
+	if err != nil {
+		postFailed("Failed to encode comments: %v", err)
+		return
+	}
+	err = writeFile(filename, buff.Bytes())
+	if err != nil {
+		postFailed("Failed to create comments file: %v", err)
+	}
+}
+
+// writeComments writes the site-wide comment files, once all the posts are done
+func writeComments() {
+	switch commentFormat {
+	case "isso":
+		writeIssoComments(commentThreads)
+	case "remark42":
+		writeRemark42Comments(commentThreads)
+	case "disqus":
+		writeDisqusComments(commentThreads)
+	}
+}
+
+// fixCommentBody runs the body of a comment through the same link and image
+// rewriting as posts, copying any assets alongside the post
+func fixCommentBody(c *Comment, p Post, outputDir string) {
+	sourceUrl, err := url.Parse(p.Link)
+	if err != nil {
+		warn("Failed to parse post url '%s': %v", p.Link, err)
+		return
+	}
+	name := fmt.Sprintf("comment %d on %s", c.ID, p.Link)
+	c.Content.Rendered = rewriteHTML(name, c.Content.Rendered, outputDir, sourceUrl, postUrlPath(p))
+}
+
+// Comments whose dates we've already warned about
+var badCommentDates = map[int]bool{}
+
+func commentTime(c Comment) time.Time {
+	t, err := time.Parse("2006-01-02T15:04:05", c.DateGMT)
+	if err != nil && !badCommentDates[c.ID] {
+		badCommentDates[c.ID] = true
+		warn("Failed to parse date for comment %d '%s': %v", c.ID, c.DateGMT, err)
+	}
+	return t
+}
+
+// commentRoots maps each comment to the top level comment of its thread,
+// for comment systems that only support one level of replies
+func commentRoots(comments []Comment) map[int]int {
+	parents := map[int]int{}
+	for _, c := range comments {
+		parents[c.ID] = c.Parent
+	}
+	roots := map[int]int{}
+	for _, c := range comments {
+		id := c.ID
+		for i := 0; i < len(comments) && parents[id] != 0; i++ {
+			if _, ok := parents[parents[id]]; !ok {
+				// replying to a comment we don't have
+				break
+			}
+			id = parents[id]
+		}
+		roots[c.ID] = id
+	}
+	return roots
+}
+
+// siteLink is the absolute url of a post on the new site
+func siteLink(p Post) string {
+	base := siteUrl
+	if base == "" {
+		u, err := url.Parse(p.Link)
+		if err != nil {
+			return postUrlPath(p)
+		}
+		base = u.Scheme + "://" + u.Host
+	}
+	return strings.TrimSuffix(base, "/") + postUrlPath(p)
+}
+
+func md5Hex(s string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(s)))
+}
+
+func sha1Hex(s string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(s)))
+}
+
+type StaticmanComment struct {
+	ID      string `yaml:"_id"`
+	Parent  string `yaml:"_parent,omitempty"`
+	Name    string `yaml:"name"`
+	Email   string `yaml:"email,omitempty"`
+	URL     string `yaml:"url,omitempty"`
+	Message string `yaml:"message"`
+	Date    int64  `yaml:"date"`
+}
+
+// Staticman keeps one yaml file per comment, under _data/comments/<slug>/
+func writeStaticmanComments(p Post, comments []Comment) {
+	dir := filepath.Join(dest, "_data", "comments", p.Slug)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		postFailed("Failed to create directory %s: %v", dir, err)
+		return
+	}
+	for _, c := range comments {
+		sc := StaticmanComment{
+			ID:      strconv.Itoa(c.ID),
+			Name:    c.AuthorName,
+			URL:     c.AuthorURL,
+			Message: c.Content.Rendered,
+			Date:    commentTime(c).Unix(),
+		}
+		if c.Parent != 0 {
+			sc.Parent = strconv.Itoa(c.Parent)
+		}
+		sc.Email = commentEmailHash(c)
+		data, err := yaml.Marshal(sc)
+		if err != nil {
+			postFailed("Failed to encode comment %d: %v", c.ID, err)
+			continue
+		}
+		filename := filepath.Join(dir, fmt.Sprintf("%d.yml", c.ID))
+		err = writeFile(filename, data)
+		if err != nil {
+			postFailed("Failed to write %s: %v", filename, err)
+		}
+	}
+}
+
+const issoSchema = `
+CREATE TABLE preferences (key VARCHAR PRIMARY KEY, value VARCHAR);
+CREATE TABLE threads (id INTEGER PRIMARY KEY, uri VARCHAR(256) UNIQUE, title VARCHAR(256));
+CREATE TABLE comments (
+    tid REFERENCES threads(id), id INTEGER PRIMARY KEY, parent INTEGER,
+    created FLOAT NOT NULL, modified FLOAT, mode INTEGER, remote_addr VARCHAR,
+    text VARCHAR, author VARCHAR, email VARCHAR, website VARCHAR,
+    likes INTEGER DEFAULT 0, dislikes INTEGER DEFAULT 0, voters BLOB NOT NULL,
+    notification INTEGER DEFAULT 0);
+PRAGMA user_version = 4;
+`
+
+// Isso uses an sqlite database, and only one level of replies
+func writeIssoComments(threads []CommentThread) {
+	filename := filepath.Join(dest, "comments.db")
+	// Build the database alongside, and only replace the old one when it's done
+	tempFile := filepath.Join(dest, ".comments.db.tmp")
+	_ = os.Remove(tempFile)
+	addTempFile(tempFile)
+	defer removeTempFile(tempFile)
+	db, err := sql.Open("sqlite", tempFile)
+	if err != nil {
+		fatal("Failed to create %s: %v", filename, err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		fatal("Failed to write %s: %v", filename, err)
+	}
+	_, err = tx.Exec(issoSchema)
+	if err != nil {
+		fatal("Failed to create tables in %s: %v", filename, err)
+	}
+	count := 0
+	for _, t := range threads {
+		res, err := tx.Exec("INSERT INTO threads (uri, title) VALUES (?, ?)", postUrlPath(t.Post), htmlText(t.Post.Title.Rendered))
+		if err != nil {
+			fatal("Failed to add thread for %s to %s: %v", t.Post.Link, filename, err)
+		}
+		tid, _ := res.LastInsertId()
+		roots := commentRoots(t.Comments)
+		for _, c := range t.Comments {
+			var parent interface{}
+			if roots[c.ID] != c.ID {
+				parent = roots[c.ID]
+			}
+			created := float64(commentTime(c).Unix())
+			_, err = tx.Exec(`INSERT INTO comments (tid, id, parent, created, modified, mode, remote_addr, text, author, email, website, voters)
+				VALUES (?, ?, ?, ?, NULL, 1, ?, ?, ?, ?, ?, ?)`,
+				tid, c.ID, parent, created, c.AuthorIP, c.Content.Rendered, c.AuthorName, nullable(c.AuthorEmail), nullable(c.AuthorURL), make([]byte, 256))
+			if err != nil {
+				fatal("Failed to add comment %d to %s: %v", c.ID, filename, err)
+			}
+			count++
+		}
+	}
+	err = tx.Commit()
+	if err == nil {
+		err = db.Close()
+	}
+	if err == nil {
+		err = os.Rename(tempFile, filename)
+	}
+	if err != nil {
+		fatal("Failed to write %s: %v", filename, err)
+	}
+	info("Saved %d comments to %s", count, filename)
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+type Remark42User struct {
+	Name    string `json:"name"`
+	ID      string `json:"id"`
+	Picture string `json:"picture"`
+	IP      string `json:"ip"`
+	Admin   bool   `json:"admin"`
+	SiteID  string `json:"site_id"`
+}
+
+type Remark42Locator struct {
+	Site string `json:"site"`
+	URL  string `json:"url"`
+}
+
+type Remark42Comment struct {
+	ID        string          `json:"id"`
+	ParentID  string          `json:"pid"`
+	Text      string          `json:"text"`
+	User      Remark42User    `json:"user"`
+	Locator   Remark42Locator `json:"locator"`
+	Score     int             `json:"score"`
+	Vote      int             `json:"vote"`
+	Timestamp time.Time       `json:"time"`
+	Title     string          `json:"title"`
+}
+
+// Remark42 imports its own backup format, a header line followed by one
+// json comment per line
+func writeRemark42Comments(threads []CommentThread) {
+	var buff bytes.Buffer
+	enc := json.NewEncoder(&buff)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(map[string]interface{}{"version": 1, "users": []interface{}{}, "posts": []interface{}{}})
+	count := 0
+	for _, t := range threads {
+		for _, c := range t.Comments {
+			rc := Remark42Comment{
+				ID:   fmt.Sprintf("wp-%d", c.ID),
+				Text: c.Content.Rendered,
+				User: Remark42User{
+					Name:    c.AuthorName,
+					ID:      "wordpress_" + sha1Hex(commentEmailHash(c)+c.AuthorName),
+					Picture: avatarUrl(c),
+					SiteID:  remark42Site,
+				},
+				Locator:   Remark42Locator{Site: remark42Site, URL: siteLink(t.Post)},
+				Timestamp: commentTime(c),
+				Title:     htmlText(t.Post.Title.Rendered),
+			}
+			if c.AuthorIP != "" {
+				rc.User.IP = sha1Hex(c.AuthorIP)
+			}
+			if c.Parent != 0 {
+				rc.ParentID = fmt.Sprintf("wp-%d", c.Parent)
+			}
+			err := enc.Encode(rc)
+			if err != nil {
+				fatal("Failed to encode comment %d: %v", c.ID, err)
+			}
+			count++
+		}
+	}
+	filename := filepath.Join(dest, "remark42.json")
+	err := writeFile(filename, buff.Bytes())
+	if err != nil {
+		fatal("Failed to write %s: %v", filename, err)
+	}
+	info("Saved %d comments to %s", count, filename)
+}
+
+// avatarUrl picks the largest avatar WordPress gave us
+func avatarUrl(c Comment) string {
+	best := 0
+	ret := ""
+	for size, u := range c.AuthorAvatarURLs {
+		n, _ := strconv.Atoi(size)
+		if n > best {
+			best = n
+			ret = u
+		}
+	}
+	return ret
+}
+
+// Disqus imports a subset of the WordPress WXR export format
+func writeDisqusComments(threads []CommentThread) {
+	var b strings.Builder
+	x := func(s string) string {
+		var eb strings.Builder
+		_ = xml.EscapeText(&eb, []byte(s))
+		return eb.String()
+	}
+	wpDate := func(c Comment) string {
+		return commentTime(c).Format("2006-01-02 15:04:05")
+	}
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/" xmlns:dsq="http://www.disqus.com/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:wp="http://wordpress.org/export/1.0/">
+<channel>
+`)
+	count := 0
+	for _, t := range threads {
+		p := t.Post
+		b.WriteString("<item>\n")
+		fmt.Fprintf(&b, "<title>%s</title>\n", x(htmlText(p.Title.Rendered)))
+		fmt.Fprintf(&b, "<link>%s</link>\n", x(siteLink(p)))
+		b.WriteString("<content:encoded></content:encoded>\n")
+		fmt.Fprintf(&b, "<dsq:thread_identifier>%d</dsq:thread_identifier>\n", p.ID)
+		fmt.Fprintf(&b, "<wp:post_date_gmt>%s</wp:post_date_gmt>\n", x(strings.Replace(p.DateGmt, "T", " ", 1)))
+		b.WriteString("<wp:comment_status>open</wp:comment_status>\n")
+		for _, c := range t.Comments {
+			b.WriteString("<wp:comment>\n")
+			fmt.Fprintf(&b, "<wp:comment_id>%d</wp:comment_id>\n", c.ID)
+			fmt.Fprintf(&b, "<wp:comment_author>%s</wp:comment_author>\n", x(c.AuthorName))
+			fmt.Fprintf(&b, "<wp:comment_author_email>%s</wp:comment_author_email>\n", x(c.AuthorEmail))
+			fmt.Fprintf(&b, "<wp:comment_author_url>%s</wp:comment_author_url>\n", x(c.AuthorURL))
+			fmt.Fprintf(&b, "<wp:comment_author_IP>%s</wp:comment_author_IP>\n", x(c.AuthorIP))
+			fmt.Fprintf(&b, "<wp:comment_date_gmt>%s</wp:comment_date_gmt>\n", wpDate(c))
+			fmt.Fprintf(&b, "<wp:comment_content>%s</wp:comment_content>\n", x(c.Content.Rendered))
+			fmt.Fprintf(&b, "<wp:comment_approved>%s</wp:comment_approved>\n", wxrApproved(c.Status))
+			fmt.Fprintf(&b, "<wp:comment_parent>%d</wp:comment_parent>\n", c.Parent)
+			b.WriteString("</wp:comment>\n")
+			count++
+		}
+		b.WriteString("</item>\n")
+	}
+	b.WriteString("</channel>\n</rss>\n")
+	filename := filepath.Join(dest, "disqus.xml")
+	err := writeFile(filename, []byte(b.String()))
+	if err != nil {
+		fatal("Failed to write %s: %v", filename, err)
+	}
+	info("Saved %d comments to %s", count, filename)
+}
+
+// wxrApproved is how WordPress export files give the status of a comment
+func wxrApproved(status string) string {
+	switch status {
+	case "approved", "approve", "":
+		return "1"
+	case "spam", "trash":
+		return status
+	default:
+		return "0"
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestCommentRoots(t *testing.T) {
+	comments := []Comment{
+		{ID: 1},
+		{ID: 2, Parent: 1},
+		{ID: 3, Parent: 2},
+		{ID: 4},
+		{ID: 5, Parent: 99},
+		{ID: 6, Parent: 5},
+	}
+	want := map[int]int{1: 1, 2: 1, 3: 1, 4: 4, 5: 5, 6: 5}
+	if got := commentRoots(comments); !maps.Equal(got, want) {
+		t.Errorf("commentRoots = %v, want %v", got, want)
+	}
+}
+
+func TestCommentTime(t *testing.T) {
+	setFor(t, &badCommentDates, map[int]bool{})
+	tests := []struct {
+		date string
+		want time.Time
+	}{
+		{"2020-01-02T03:04:05", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"", time.Time{}},
+		{"yesterday", time.Time{}},
+	}
+	for i, tt := range tests {
+		if got := commentTime(Comment{ID: i, DateGMT: tt.date}); !got.Equal(tt.want) {
+			t.Errorf("commentTime(%q) = %v, want %v", tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestWxrApproved(t *testing.T) {
+	tests := []struct {
+		status string
+		want   string
+	}{
+		{"approved", "1"},
+		{"approve", "1"},
+		{"", "1"},
+		{"hold", "0"},
+		{"unapproved", "0"},
+		{"spam", "spam"},
+		{"trash", "trash"},
+	}
+	for _, tt := range tests {
+		if got := wxrApproved(tt.status); got != tt.want {
+			t.Errorf("wxrApproved(%q) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestAvatarUrl(t *testing.T) {
+	tests := []struct {
+		avatars map[string]string
+		want    string
+	}{
+		{nil, ""},
+		{map[string]string{"24": "a24", "96": "a96", "48": "a48"}, "a96"},
+		{map[string]string{"small": "bad", "48": "a48"}, "a48"},
+	}
+	for _, tt := range tests {
+		if got := avatarUrl(Comment{AuthorAvatarURLs: tt.avatars}); got != tt.want {
+			t.Errorf("avatarUrl(%v) = %q, want %q", tt.avatars, got, tt.want)
+		}
+	}
+}
+
+func TestSiteLink(t *testing.T) {
+	setFor(t, &prefix, "")
+	setFor(t, &sitePath, "")
+	setFor(t, &languageLayout, "tree")
+	p := Post{ID: 5, Slug: "first", Link: "https://example.com/2020/01/first/"}
+	tests := []struct {
+		siteUrl string
+		want    string
+	}{
+		{"", "https://example.com/2020/01/first/"},
+		{"https://new.example/", "https://new.example/2020/01/first/"},
+		{"https://new.example", "https://new.example/2020/01/first/"},
+	}
+	for _, tt := range tests {
+		setFor(t, &siteUrl, tt.siteUrl)
+		if got := siteLink(p); got != tt.want {
+			t.Errorf("siteLink with site url %q = %q, want %q", tt.siteUrl, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	// So that we can find the site's timezone on systems without a zoneinfo database
+	_ "time/tzdata"
+)
+
+// How WordPress formats the dates of posts
+const wpDateLayout = "2006-01-02T15:04:05"
+
+// The timezone of the site we're exporting, if we know it
+var siteLocation *time.Location
+
+// siteTimezone works out the timezone of the site from its API index
+func siteTimezone(index *SiteIndex) *time.Location {
+	if index == nil {
+		return nil
+	}
+	if index.TimezoneString != "" {
+		loc, err := time.LoadLocation(index.TimezoneString)
+		if err == nil {
+			return loc
+		}
+		warn("Unknown timezone '%s': %v", index.TimezoneString, err)
+	}
+	// Sites set to a UTC offset rather than a city
+	return time.FixedZone(fmt.Sprintf("UTC%+g", index.GmtOffset), int(index.GmtOffset*3600))
+}
+
+// postTime converts the local and GMT dates WordPress gives us into RFC3339,
+// in the site's timezone or in UTC
+func postTime(local string, gmt string) (string, error) {
+	t, err := time.ParseInLocation(wpDateLayout, gmt, time.UTC)
+	if err != nil {
+		// Some posts don't have a GMT date, so trust the local one
+		if siteLocation == nil {
+			return "", err
+		}
+		t, err = time.ParseInLocation(wpDateLayout, local, siteLocation)
+		if err != nil {
+			return "", err
+		}
+	}
+	if dateZone == "utc" {
+		return t.UTC().Format(time.RFC3339), nil
+	}
+	if siteLocation != nil {
+		return t.In(siteLocation).Format(time.RFC3339), nil
+	}
+	// Without a timezone, the difference between the two dates is the offset
+	lt, err := time.ParseInLocation(wpDateLayout, local, time.UTC)
+	if err != nil {
+		return t.UTC().Format(time.RFC3339), nil
+	}
+	return t.In(time.FixedZone("", int(lt.Sub(t).Seconds()))).Format(time.RFC3339), nil
+}
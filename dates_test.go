@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSiteTimezone(t *testing.T) {
+	tests := []struct {
+		index *SiteIndex
+		want  string
+	}{
+		{&SiteIndex{TimezoneString: "Europe/London"}, "2020-07-01T13:00:00+01:00"},
+		{&SiteIndex{TimezoneString: "Nowhere/Special", GmtOffset: -5}, "2020-07-01T07:00:00-05:00"},
+		{&SiteIndex{GmtOffset: 5.5}, "2020-07-01T17:30:00+05:30"},
+		{&SiteIndex{}, "2020-07-01T12:00:00Z"},
+	}
+	noon := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		if got := noon.In(siteTimezone(tt.index)).Format(time.RFC3339); got != tt.want {
+			t.Errorf("siteTimezone(%+v) gives %s, want %s", *tt.index, got, tt.want)
+		}
+	}
+	if siteTimezone(nil) != nil {
+		t.Errorf("siteTimezone(nil) isn't nil")
+	}
+}
+
+func TestPostTime(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		zone     string
+		location *time.Location
+		local    string
+		gmt      string
+		want     string
+		wantErr  bool
+	}{
+		{"local", london, "2020-07-01T13:00:00", "2020-07-01T12:00:00", "2020-07-01T13:00:00+01:00", false},
+		{"local", london, "2020-01-01T12:00:00", "2020-01-01T12:00:00", "2020-01-01T12:00:00Z", false},
+		{"utc", london, "2020-07-01T13:00:00", "2020-07-01T12:00:00", "2020-07-01T12:00:00Z", false},
+		{"local", nil, "2020-07-01T14:30:00", "2020-07-01T12:00:00", "2020-07-01T14:30:00+02:30", false},
+		{"local", nil, "", "2020-07-01T12:00:00", "2020-07-01T12:00:00Z", false},
+		{"local", london, "2020-07-01T13:00:00", "0000-00-00T00:00:00", "2020-07-01T13:00:00+01:00", false},
+		{"utc", london, "2020-07-01T13:00:00", "", "2020-07-01T12:00:00Z", false},
+		{"local", nil, "2020-07-01T13:00:00", "", "", true},
+		{"local", london, "someday", "", "", true},
+	}
+	for _, tt := range tests {
+		setFor(t, &dateZone, tt.zone)
+		setFor(t, &siteLocation, tt.location)
+		got, err := postTime(tt.local, tt.gmt)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("postTime(%q, %q) in %v with %s dates = %q, %v, want %q", tt.local, tt.gmt, tt.location, tt.zone, got, err, tt.want)
+		}
+	}
+}
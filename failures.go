@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PostFailure is what went wrong with a post, or with another page of the
+// site such as a category, with --keep-going
+type PostFailure struct {
+	Site              string `json:",omitempty"`
+	ID                int    `json:",omitempty"`
+	Link              string
+	Skipped           bool
+	MissingAuthor     int   `json:",omitempty"`
+	MissingCategories []int `json:",omitempty"`
+	MissingTags       []int `json:",omitempty"`
+	Problems          []string
+}
+
+var failures []*PostFailure
+
+// postFailed reports a problem with the post we're working on. Without
+// --keep-going it's fatal, otherwise we note it for the failures report and
+// carry on, returning the record of the post so that the caller can add
+// what it knows.
+func postFailed(format string, a ...interface{}) *PostFailure {
+	if !keepGoing {
+		fatal(format, a...)
+	}
+	msg := fmt.Sprintf(format, a...)
+	warn("%s", msg)
+	f := currentFailure()
+	f.Problems = append(f.Problems, msg)
+	return f
+}
+
+// currentFailure finds the record of what we're working on. Problems that
+// aren't with a post, such as with the site's icon, share a record for the
+// page or the site they're on.
+func currentFailure() *PostFailure {
+	for _, f := range failures {
+		if f.Site == sitePath && f.ID == logContext.PostID && f.Link == logContext.PostLink {
+			return f
+		}
+	}
+	f := &PostFailure{Site: sitePath, ID: logContext.PostID, Link: logContext.PostLink}
+	failures = append(failures, f)
+	return f
+}
+
+// hasFailed is whether we've had problems with what we're working on
+func hasFailed() bool {
+	return slices.ContainsFunc(failures, func(f *PostFailure) bool {
+		return f.Site == sitePath && f.ID == logContext.PostID && f.Link == logContext.PostLink
+	})
+}
+
+// A post ID, or site/ID for a post of one of the sites of a network
+var postKeyRe = regexp.MustCompile(`^(?:[^/]+/)?[0-9]+$`)
+
+// failedKey is how --only-ids names the post of a failure
+func (f *PostFailure) failedKey() string {
+	id := strconv.Itoa(f.ID)
+	if f.Site == "" {
+		return id
+	}
+	return strings.Trim(f.Site, "/") + "/" + id
+}
+
+// selected is whether we're saving a post, as we only save some with --only-ids
+func selected(p Post) bool {
+	if len(onlyIDs) == 0 {
+		return true
+	}
+	f := PostFailure{Site: sitePath, ID: p.ID}
+	return slices.Contains(onlyIDs, f.failedKey())
+}
+
+// writeFailures saves the failures report, and the IDs of the posts that
+// failed in a form --only-ids takes, so they can be tried again
+func writeFailures() {
+	writeMeta("failures", failures)
+	ids := []string{}
+	for _, f := range failures {
+		if f.ID != 0 {
+			ids = append(ids, f.failedKey())
+		}
+	}
+	if len(ids) == 0 {
+		warn("There were problems, see failures.json")
+		return
+	}
+	filename := filepath.Join(dest, "failed-ids.txt")
+	err := writeFile(filename, []byte(strings.Join(ids, ",")+"\n"))
+	if err != nil {
+		fatal("Failed to write %s: %v", filename, err)
+	}
+	warn("%d posts had problems, see failures.json and retry them with --only-ids %s", len(ids), strings.Join(ids, ","))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPostKeyRe(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"12", true},
+		{"chemistry/12", true},
+		{"dept-physics/7", true},
+		{"", false},
+		{"12a", false},
+		{"chemistry/", false},
+		{"a/b/12", false},
+		{"/12", false},
+	}
+	for _, tt := range tests {
+		if got := postKeyRe.MatchString(tt.key); got != tt.want {
+			t.Errorf("postKeyRe.MatchString(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestFailedKey(t *testing.T) {
+	tests := []struct {
+		failure PostFailure
+		want    string
+	}{
+		{PostFailure{ID: 12}, "12"},
+		{PostFailure{Site: "/chemistry", ID: 12}, "chemistry/12"},
+	}
+	for _, tt := range tests {
+		if got := tt.failure.failedKey(); got != tt.want {
+			t.Errorf("failedKey(%+v) = %q, want %q", tt.failure, got, tt.want)
+		}
+	}
+}
+
+func TestSelected(t *testing.T) {
+	tests := []struct {
+		onlyIDs  []string
+		sitePath string
+		id       int
+		want     bool
+	}{
+		{nil, "", 12, true},
+		{[]string{"12", "15"}, "", 12, true},
+		{[]string{"12", "15"}, "", 13, false},
+		{[]string{"chemistry/12"}, "/chemistry", 12, true},
+		{[]string{"chemistry/12"}, "/biology", 12, false},
+		{[]string{"12"}, "/chemistry", 12, false},
+	}
+	for _, tt := range tests {
+		setFor(t, &onlyIDs, tt.onlyIDs)
+		setFor(t, &sitePath, tt.sitePath)
+		if got := selected(Post{ID: tt.id}); got != tt.want {
+			t.Errorf("selected(%d) on %q with --only-ids %v = %v, want %v", tt.id, tt.sitePath, tt.onlyIDs, got, tt.want)
+		}
+	}
+}
+
+func TestPostFailed(t *testing.T) {
+	setFor(t, &keepGoing, true)
+	setFor(t, &failures, nil)
+	setFor(t, &sitePath, "")
+	setFor(t, &logContext, LogContext{})
+	setFor(t, &dest, t.TempDir())
+
+	workingOn(LogContext{PostID: 5, PostLink: "https://example.com/first/"})
+	if hasFailed() {
+		t.Errorf("post 5 has failed before anything went wrong")
+	}
+	postFailed("Failed to copy %s", "a.jpg")
+	postFailed("Failed to copy %s", "b.jpg").MissingAuthor = 3
+	if !hasFailed() {
+		t.Errorf("post 5 hasn't failed")
+	}
+	workingOn(LogContext{PostLink: "https://example.com/category/news/"})
+	postFailed("Failed to write category")
+	workingOn(LogContext{})
+
+	want := []PostFailure{
+		{ID: 5, Link: "https://example.com/first/", MissingAuthor: 3, Problems: []string{"Failed to copy a.jpg", "Failed to copy b.jpg"}},
+		{Link: "https://example.com/category/news/", Problems: []string{"Failed to write category"}},
+	}
+	if len(failures) != len(want) {
+		t.Fatalf("%d failures, want %d", len(failures), len(want))
+	}
+	for i, f := range failures {
+		if !reflect.DeepEqual(*f, want[i]) {
+			t.Errorf("failure %d = %+v, want %+v", i, *f, want[i])
+		}
+	}
+
+	writeFailures()
+	ids, err := os.ReadFile(filepath.Join(dest, "failed-ids.txt"))
+	if err != nil || string(ids) != "5\n" {
+		t.Errorf("failed-ids.txt = %q, %v, want just post 5", ids, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "failures.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved []PostFailure
+	if err := json.Unmarshal(data, &saved); err != nil || len(saved) != 2 {
+		t.Errorf("failures.json = %s, %v, want both failures", data, err)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FieldMapping describes how to copy ACF fields and post meta into the
+// frontmatter, read from the file given with --fields
+type FieldMapping struct {
+	// Fields that aren't listed are copied under their own name, unless this is set
+	DropUnmapped bool `yaml:"drop-unmapped"`
+	// Keyed by acf.<name> or meta.<key>
+	Fields map[string]FieldRule `yaml:"fields"`
+}
+
+type FieldRule struct {
+	Key  string `yaml:"key"`
+	Type string `yaml:"type"`
+	Drop bool   `yaml:"drop"`
+}
+
+var fieldTypes = []string{"", "string", "int", "float", "bool", "date", "list", "image", "file"}
+
+// Frontmatter keys we set ourselves, which taxonomies and fields mustn't use
+var reservedKeys = []string{"template", "title", "date", "lastmod", "excerpt", "author", "authorSlug", "authors",
+	"categories", "categoryPaths", "tags", "aliases", "gallery", "seo", "lang", "translationKey"}
+
+var fieldMapping *FieldMapping
+
+func readFieldMapping(filename string) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		fatal("Failed to read '%s': %v", filename, err)
+	}
+	fieldMapping = &FieldMapping{}
+	err = yaml.UnmarshalStrict(data, fieldMapping)
+	if err != nil {
+		fatal("Failed to parse '%s': %v", filename, err)
+	}
+	for name, rule := range fieldMapping.Fields {
+		if !strings.HasPrefix(name, "acf.") && !strings.HasPrefix(name, "meta.") {
+			fatal("Field '%s' in %s should be acf.<name> or meta.<key>", name, filename)
+		}
+		if !slices.Contains(fieldTypes, rule.Type) {
+			fatal("Field '%s' in %s has unknown type '%s', should be one of %s", name, filename, rule.Type, strings.Join(fieldTypes[1:], ", "))
+		}
+		if !rule.Drop && slices.Contains(reservedKeys, fieldKey(name, rule)) {
+			fatal("Can't save field '%s' as '%s', give it a different key in %s", name, fieldKey(name, rule), filename)
+		}
+	}
+}
+
+func fieldKey(name string, rule FieldRule) string {
+	if rule.Key != "" {
+		return rule.Key
+	}
+	_, key, _ := strings.Cut(name, ".")
+	return key
+}
+
+// postFields flattens the ACF fields and post meta of a post into
+// frontmatter, copying any attachments they refer to into dir
+func postFields(p Post, dir string, sourceUrl *url.URL) map[string]interface{} {
+	ret := map[string]interface{}{}
+	if fieldMapping == nil {
+		return ret
+	}
+	for _, group := range []string{"acf", "meta"} {
+		// WordPress sends an empty list rather than an empty object
+		values, ok := p.Extra[group].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for field, value := range values {
+			name := group + "." + field
+			rule, mapped := fieldMapping.Fields[name]
+			if rule.Drop || (!mapped && fieldMapping.DropUnmapped) {
+				continue
+			}
+			key := fieldKey(name, rule)
+			if slices.Contains(reservedKeys, key) {
+				warn("Not saving %s of %s as '%s', give it a different key with --fields", name, p.Link, key)
+				continue
+			}
+			converted, err := convertField(value, rule.Type, dir, sourceUrl)
+			if err != nil {
+				warn("Failed to convert %s of %s to %s: %v", name, p.Link, rule.Type, err)
+				continue
+			}
+			if _, ok := ret[key]; ok {
+				warn("More than one field of %s would be saved as '%s'", p.Link, key)
+			}
+			ret[key] = converted
+		}
+	}
+	return ret
+}
+
+func convertField(value interface{}, kind string, dir string, sourceUrl *url.URL) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	switch kind {
+	case "":
+		return value, nil
+	case "string":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return fmt.Sprint(value), nil
+	case "int":
+		switch v := value.(type) {
+		case float64:
+			return int(v), nil
+		case string:
+			if strings.TrimSpace(v) == "" {
+				return nil, nil
+			}
+			return strconv.Atoi(strings.TrimSpace(v))
+		}
+	case "float":
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case string:
+			if strings.TrimSpace(v) == "" {
+				return nil, nil
+			}
+			return strconv.ParseFloat(strings.TrimSpace(v), 64)
+		}
+	case "bool":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case float64:
+			return v != 0, nil
+		case string:
+			return v != "" && v != "0" && v != "false", nil
+		}
+	case "date":
+		if s, ok := value.(string); ok {
+			return fieldDate(s)
+		}
+	case "list":
+		if l, ok := value.([]interface{}); ok {
+			return l, nil
+		}
+		if s, ok := value.(string); ok && s == "" {
+			return []interface{}{}, nil
+		}
+		return []interface{}{value}, nil
+	case "image", "file":
+		// Gallery and repeater fields hold a list of attachments
+		if l, ok := value.([]interface{}); ok {
+			files := []interface{}{}
+			for _, v := range l {
+				f, err := attachmentField(v, dir, sourceUrl)
+				if err != nil {
+					return nil, err
+				}
+				if f != "" {
+					files = append(files, f)
+				}
+			}
+			return files, nil
+		}
+		f, err := attachmentField(value, dir, sourceUrl)
+		if err != nil || f == "" {
+			return nil, err
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("unexpected value %v", value)
+}
+
+var fieldDateLayouts = []string{"20060102", "2006-01-02", "2006-01-02 15:04:05", "2006-01-02T15:04:05", time.RFC3339}
+
+// fieldDate handles the formats ACF date pickers save dates in
+func fieldDate(s string) (interface{}, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	for _, layout := range fieldDateLayouts {
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			continue
+		}
+		if strings.Contains(layout, "15") {
+			return t.Format("2006-01-02T15:04:05"), nil
+		}
+		return t.Format("2006-01-02"), nil
+	}
+	return nil, fmt.Errorf("unrecognised date '%s'", s)
+}
+
+// attachmentField copies the file an image or file field refers to, whether
+// ACF returns it as an attachment ID, a url or an array
+func attachmentField(value interface{}, dir string, sourceUrl *url.URL) (string, error) {
+	u := ""
+	switch v := value.(type) {
+	case float64:
+		u = attachmentUrl(int(v))
+	case string:
+		if id, err := strconv.Atoi(v); err == nil {
+			u = attachmentUrl(id)
+		} else {
+			u = v
+		}
+	case map[string]interface{}:
+		if s, ok := v["url"].(string); ok {
+			u = s
+		} else if id, ok := v["ID"].(float64); ok {
+			u = attachmentUrl(int(id))
+		} else if id, ok := v["id"].(float64); ok {
+			u = attachmentUrl(int(id))
+		}
+	default:
+		return "", fmt.Errorf("unexpected value %v", value)
+	}
+	if u == "" {
+		return "", nil
+	}
+	asset := copyImage(u, sourceUrl)
+	if asset == nil {
+		return u, nil
+	}
+	return fetchAsset(asset, dir), nil
+}
+
+// Attachment urls we've already looked up
+var attachmentUrls = map[int]string{}
+
+func attachmentUrl(id int) string {
+	if id == 0 {
+		return ""
+	}
+	if u, ok := attachmentUrls[id]; ok {
+		return u
+	}
+	u := ""
+	media, err := fetchMedia(fmt.Sprintf("include=%d", id))
+	if err != nil {
+		postFailed("Failed to look up attachment %d: %v", id, err)
+	} else if len(media) == 0 {
+		warn("No such attachment as %d", id)
+	} else {
+		u = media[0].SourceURL
+	}
+	attachmentUrls[id] = u
+	return u
+}
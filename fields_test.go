@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConvertField(t *testing.T) {
+	tests := []struct {
+		value   interface{}
+		kind    string
+		want    interface{}
+		wantErr bool
+	}{
+		{nil, "int", nil, false},
+		{"as is", "", "as is", false},
+		{map[string]interface{}{"a": 1.0}, "", map[string]interface{}{"a": 1.0}, false},
+		{"text", "string", "text", false},
+		{12.0, "string", "12", false},
+		{true, "string", "true", false},
+		{12.0, "int", 12, false},
+		{" 42 ", "int", 42, false},
+		{"", "int", nil, false},
+		{"lots", "int", 0, true},
+		{true, "int", nil, true},
+		{1.5, "float", 1.5, false},
+		{"2.25", "float", 2.25, false},
+		{" ", "float", nil, false},
+		{"much", "float", 0.0, true},
+		{true, "bool", true, false},
+		{0.0, "bool", false, false},
+		{1.0, "bool", true, false},
+		{"1", "bool", true, false},
+		{"0", "bool", false, false},
+		{"false", "bool", false, false},
+		{"", "bool", false, false},
+		{"20240131", "date", "2024-01-31", false},
+		{"2024-01-31 13:45:00", "date", "2024-01-31T13:45:00", false},
+		{"", "date", nil, false},
+		{"soon", "date", nil, true},
+		{12.0, "date", nil, true},
+		{[]interface{}{"a", "b"}, "list", []interface{}{"a", "b"}, false},
+		{"", "list", []interface{}{}, false},
+		{"a", "list", []interface{}{"a"}, false},
+	}
+	for _, tt := range tests {
+		got, err := convertField(tt.value, tt.kind, "", nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("convertField(%v, %q) error = %v, want error %v", tt.value, tt.kind, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("convertField(%v, %q) = %#v, want %#v", tt.value, tt.kind, got, tt.want)
+		}
+	}
+}
+
+func TestFieldDate(t *testing.T) {
+	tests := []struct {
+		date string
+		want interface{}
+	}{
+		{"20240131", "2024-01-31"},
+		{"2024-01-31", "2024-01-31"},
+		{"2024-01-31 13:45:00", "2024-01-31T13:45:00"},
+		{"2024-01-31T13:45:00", "2024-01-31T13:45:00"},
+		{"2024-01-31T13:45:00+02:00", "2024-01-31T13:45:00"},
+		{"  ", nil},
+	}
+	for _, tt := range tests {
+		got, err := fieldDate(tt.date)
+		if err != nil || got != tt.want {
+			t.Errorf("fieldDate(%q) = %v, %v, want %v", tt.date, got, err, tt.want)
+		}
+	}
+	if _, err := fieldDate("31/01/2024"); err == nil {
+		t.Errorf("fieldDate(31/01/2024) succeeded, want an error")
+	}
+}
+
+func TestFieldKey(t *testing.T) {
+	tests := []struct {
+		name string
+		rule FieldRule
+		want string
+	}{
+		{"acf.subtitle", FieldRule{}, "subtitle"},
+		{"meta._thumbnail.id", FieldRule{}, "_thumbnail.id"},
+		{"acf.subtitle", FieldRule{Key: "strapline"}, "strapline"},
+	}
+	for _, tt := range tests {
+		if got := fieldKey(tt.name, tt.rule); got != tt.want {
+			t.Errorf("fieldKey(%q, %+v) = %q, want %q", tt.name, tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestPostFields(t *testing.T) {
+	p := Post{Link: "https://example.com/2020/01/post/", Extra: map[string]interface{}{
+		"acf": map[string]interface{}{
+			"subtitle": "Hello",
+			"rating":   "4",
+			"secret":   "shh",
+			"title":    "Clashes",
+		},
+		"meta": []interface{}{},
+	}}
+	tests := []struct {
+		mapping *FieldMapping
+		want    map[string]interface{}
+	}{
+		{nil, map[string]interface{}{}},
+		{&FieldMapping{Fields: map[string]FieldRule{
+			"acf.rating": {Type: "int"},
+			"acf.secret": {Drop: true},
+		}}, map[string]interface{}{"subtitle": "Hello", "rating": 4}},
+		{&FieldMapping{DropUnmapped: true, Fields: map[string]FieldRule{
+			"acf.subtitle": {Key: "strapline"},
+		}}, map[string]interface{}{"strapline": "Hello"}},
+	}
+	for _, tt := range tests {
+		setFor(t, &fieldMapping, tt.mapping)
+		if got := postFields(p, "", nil); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("postFields with %+v = %v, want %v", tt.mapping, got, tt.want)
+		}
+	}
+}
+
+func TestReadFieldMapping(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "fields.yml")
+	err := os.WriteFile(filename, []byte("drop-unmapped: true\nfields:\n  acf.rating:\n    key: stars\n    type: int\n  meta.views:\n    drop: true\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setFor(t, &fieldMapping, nil)
+	readFieldMapping(filename)
+	want := &FieldMapping{DropUnmapped: true, Fields: map[string]FieldRule{
+		"acf.rating": {Key: "stars", Type: "int"},
+		"meta.views": {Drop: true},
+	}}
+	if !reflect.DeepEqual(fieldMapping, want) {
+		t.Errorf("readFieldMapping = %+v, want %+v", fieldMapping, want)
+	}
+}
+
+func TestAttachmentField(t *testing.T) {
+	testApi(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/wp-json/wp/v2/media" && r.URL.Query().Get("include") == "10":
+			_ = json.NewEncoder(w).Encode([]map[string]any{
+				{"id": 10, "source_url": strings.TrimSuffix(apiUrl, "/wp-json/") + "/wp-content/uploads/photo.jpg"},
+			})
+		case r.URL.Path == "/wp-json/wp/v2/media" && r.URL.Query().Get("include") == "12":
+			http.Error(w, `{"code":"oops","message":"broken"}`, http.StatusInternalServerError)
+		case r.URL.Path == "/wp-json/wp/v2/media":
+			_ = json.NewEncoder(w).Encode([]any{})
+		case strings.HasPrefix(r.URL.Path, "/wp-content/uploads/"):
+			w.Header().Set("Content-Type", "image/jpeg")
+			_, _ = w.Write([]byte("jpeg"))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	site := strings.TrimSuffix(apiUrl, "/wp-json/")
+	sourceUrl, _ := url.Parse(site + "/2020/01/post/")
+	setFor(t, &attachmentUrls, map[int]string{})
+	setFor(t, &fetchedAssets, map[string]string{})
+	setFor(t, &mirror, false)
+	dir := t.TempDir()
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{10.0, "photo.jpg"},
+		{"10", "photo.jpg"},
+		{map[string]interface{}{"ID": 10.0, "title": "Photo"}, "photo.jpg"},
+		{map[string]interface{}{"url": site + "/wp-content/uploads/other.jpg"}, "other.jpg"},
+		{site + "/wp-content/uploads/third.jpg", "third.jpg"},
+		{"https://elsewhere.example/remote.jpg", "https://elsewhere.example/remote.jpg"},
+		{11.0, ""},
+		{0.0, ""},
+	}
+	for _, tt := range tests {
+		got, err := attachmentField(tt.value, dir, sourceUrl)
+		if err != nil || got != tt.want {
+			t.Errorf("attachmentField(%v) = %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "photo.jpg")); err != nil {
+		t.Errorf("attachment wasn't copied: %v", err)
+	}
+	if _, err := attachmentField(true, dir, sourceUrl); err == nil {
+		t.Errorf("attachmentField(true) succeeded, want an error")
+	}
+
+	// With --keep-going we carry on without the attachment, but note the post failed
+	setFor(t, &keepGoing, true)
+	setFor(t, &failures, nil)
+	if got, err := attachmentField(12.0, dir, sourceUrl); err != nil || got != "" || len(failures) != 1 {
+		t.Errorf("attachmentField of a broken attachment = %q, %v with %d failures, want nothing and one failure", got, err, len(failures))
+	}
+
+	got, err := convertField([]interface{}{10.0, 11.0, site + "/wp-content/uploads/third.jpg"}, "image", dir, sourceUrl)
+	if want := []interface{}{"photo.jpg", "third.jpg"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("convertField of a gallery field = %v, %v, want %v", got, err, want)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"gopkg.in/yaml.v2"
+)
+
+type GalleryImage struct {
+	File    string `yaml:"file" json:"file"`
+	Full    string `yaml:"full,omitempty" json:"full,omitempty"`
+	Link    string `yaml:"link,omitempty" json:"link,omitempty"`
+	Caption string `yaml:"caption,omitempty" json:"caption,omitempty"`
+	Alt     string `yaml:"alt,omitempty" json:"alt,omitempty"`
+}
+
+type Gallery struct {
+	ID      string         `yaml:"id" json:"id"`
+	Columns int            `yaml:"columns,omitempty" json:"columns,omitempty"`
+	Caption string         `yaml:"caption,omitempty" json:"caption,omitempty"`
+	Images  []GalleryImage `yaml:"images" json:"images"`
+}
+
+var galleryShortcodeRe = regexp.MustCompile(`\[gallery(\s[^\]]*)?\]`)
+var shortcodeAttrRe = regexp.MustCompile(`(\w+)\s*=\s*(?:"([^"]*)"|'([^']*)'|(\S+))`)
+var columnsClassRe = regexp.MustCompile(`^(?:gallery-)?columns-(\d+)$`)
+var classicGalleryClassRe = regexp.MustCompile(`^(?:gallery-columns|galleryid)-\d+$`)
+
+// fixGalleries finds WordPress galleries in a post, copies their images and
+// replaces each of them with the gallery markup for our target
+func fixGalleries(root *html.Node, dir string, sourceUrl *url.URL, p Post) []*Gallery {
+	if galleryMode == "none" {
+		return nil
+	}
+	galleries := []*Gallery{}
+	addGallery := func(g *Gallery) {
+		g.ID = fmt.Sprintf("gallery-%d", len(galleries)+1)
+		// The gallery markup replaces the links, so fixInternalLinks never sees them
+		for i := range g.Images {
+			if g.Images[i].Link != "" {
+				g.Images[i].Link = internalLink(g.Images[i].Link, dir, sourceUrl, postUrlPath(p))
+			}
+		}
+		galleries = append(galleries, g)
+	}
+
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode && (hasClass(node, "wp-block-gallery") || isClassicGallery(node)) {
+			g := &Gallery{}
+			galleryFromHTML(g, node, dir, sourceUrl)
+			addGallery(g)
+			replaceNode(node, target.Gallery(g))
+			return
+		}
+		if node.Type == html.TextNode && galleryShortcodeRe.MatchString(node.Data) {
+			shortcodeGalleries(node, dir, sourceUrl, p.ID, addGallery)
+			return
+		}
+		child := node.FirstChild
+		for child != nil {
+			next := child.NextSibling
+			walk(child)
+			child = next
+		}
+	}
+	walk(root)
+
+	if galleryMode == "files" {
+		for _, g := range galleries {
+			writeGalleryFile(g, dir, p.Language)
+		}
+		return nil
+	}
+	return galleries
+}
+
+// writeGalleryFile saves a gallery alongside the post in language lang
+func writeGalleryFile(g *Gallery, dir string, lang string) {
+	filename := filepath.Join(dir, languageFilename(lang, g.ID+".yml"))
+	data, err := yaml.Marshal(g)
+	if err != nil {
+		postFailed("Failed to encode %s: %v", filename, err)
+		return
+	}
+	err = writeFile(filename, data)
+	if err != nil {
+		postFailed("Failed to write %s: %v", filename, err)
+	}
+}
+
+// isClassicGallery is whether node is the markup WordPress generates for a
+// [gallery] shortcode, rather than anything else that a theme or plugin
+// gave a gallery class
+func isClassicGallery(node *html.Node) bool {
+	if !hasClass(node, "gallery") {
+		return false
+	}
+	for _, class := range strings.Fields(getAttr(node, "class")) {
+		if classicGalleryClassRe.MatchString(class) {
+			return true
+		}
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && hasClass(child, "gallery-item") {
+			return true
+		}
+	}
+	return false
+}
+
+// galleryFromHTML handles both block editor and classic gallery markup
+func galleryFromHTML(g *Gallery, node *html.Node, dir string, sourceUrl *url.URL) {
+	for _, class := range strings.Fields(getAttr(node, "class")) {
+		matches := columnsClassRe.FindStringSubmatch(class)
+		if matches != nil {
+			g.Columns, _ = strconv.Atoi(matches[1])
+		}
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.Data == "figcaption" {
+			g.Caption = textContent(child)
+		}
+	}
+
+	for _, img := range findElements(node, "img") {
+		src := getAttr(img, "src")
+		if src == "" {
+			continue
+		}
+		gi := GalleryImage{
+			Alt:     getAttr(img, "alt"),
+			Caption: imageCaption(img, node),
+		}
+		full := ""
+		for a := img.Parent; a != nil && a != node; a = a.Parent {
+			if a.Type == html.ElementNode && a.Data == "a" {
+				href := getAttr(a, "href")
+				hu, err := url.Parse(href)
+				if err == nil && plausibleSuffixRe.MatchString(strings.ToLower(hu.Path)) {
+					full = href
+				} else {
+					gi.Link = href
+				}
+				break
+			}
+		}
+		for _, key := range []string{"data-full-url", "data-orig-file"} {
+			if full == "" {
+				full = getAttr(img, key)
+			}
+		}
+		gi.File = galleryImage(src, dir, sourceUrl)
+		if full != "" && full != src {
+			gi.Full = galleryImage(full, dir, sourceUrl)
+		} else {
+			gi.Full = gi.File
+		}
+		g.Images = append(g.Images, gi)
+	}
+}
+
+// imageCaption looks for the caption belonging to a single image, stopping
+// before we reach a container holding more than one image
+func imageCaption(img *html.Node, gallery *html.Node) string {
+	for a := img.Parent; a != nil && a != gallery; a = a.Parent {
+		if len(findElements(a, "img")) > 1 {
+			return ""
+		}
+		captions := findElements(a, "figcaption", "dd")
+		if len(captions) > 0 {
+			return textContent(captions[0])
+		}
+	}
+	return ""
+}
+
+// shortcodeGalleries handles [gallery] shortcodes that WordPress didn't
+// expand, fetching the images they refer to from the media API
+func shortcodeGalleries(node *html.Node, dir string, sourceUrl *url.URL, postID int, addGallery func(*Gallery)) {
+	text := node.Data
+	var replacements []*html.Node
+	for {
+		loc := galleryShortcodeRe.FindStringIndex(text)
+		if loc == nil {
+			break
+		}
+		if loc[0] > 0 {
+			replacements = append(replacements, &html.Node{Type: html.TextNode, Data: text[:loc[0]]})
+		}
+		shortcode := text[loc[0]:loc[1]]
+		text = text[loc[1]:]
+		g := &Gallery{}
+		err := galleryFromShortcode(g, shortcode, dir, sourceUrl, postID)
+		if err != nil {
+			// Left as it was, for someone to sort out by hand
+			postFailed("Failed to fetch the images of %s: %v", shortcode, err)
+			replacements = append(replacements, &html.Node{Type: html.TextNode, Data: shortcode})
+			continue
+		}
+		addGallery(g)
+		replacements = append(replacements, &html.Node{Type: html.RawNode, Data: target.Gallery(g)})
+	}
+	if text != "" {
+		replacements = append(replacements, &html.Node{Type: html.TextNode, Data: text})
+	}
+
+	// A shortcode on its own gets wrapped in a paragraph, which we drop
+	if len(replacements) == 1 && replacements[0].Type == html.RawNode && node.Parent != nil && node.Parent.Data == "p" &&
+		node.Parent.FirstChild == node && node.NextSibling == nil {
+		node = node.Parent
+	}
+	for _, r := range replacements {
+		node.Parent.InsertBefore(r, node)
+	}
+	node.Parent.RemoveChild(node)
+}
+
+func galleryFromShortcode(g *Gallery, shortcode string, dir string, sourceUrl *url.URL, postID int) error {
+	attrs := shortcodeAttrs(shortcode)
+	g.Columns, _ = strconv.Atoi(attrs["columns"])
+
+	var media []Media
+	var err error
+	ids := strings.Trim(strings.ReplaceAll(attrs["ids"], " ", ""), ",")
+	if ids != "" {
+		media, err = fetchMedia("include=" + url.QueryEscape(ids) + "&orderby=include")
+	} else {
+		media, err = fetchMedia(fmt.Sprintf("parent=%d&media_type=image&orderby=menu_order&order=asc", postID))
+	}
+	if err != nil {
+		return err
+	}
+	for _, m := range media {
+		gi := GalleryImage{
+			File:    galleryImage(m.SourceURL, dir, sourceUrl),
+			Alt:     m.AltText,
+			Caption: strings.TrimSpace(htmlText(m.Caption.Rendered)),
+		}
+		gi.Full = gi.File
+		switch attrs["link"] {
+		case "none", "file":
+		default:
+			gi.Link = m.Link
+		}
+		g.Images = append(g.Images, gi)
+	}
+	return nil
+}
+
+// shortcodeAttrs parses the attributes of a shortcode, including quotes
+// that WordPress has made curly
+func shortcodeAttrs(shortcode string) map[string]string {
+	shortcode = strings.NewReplacer("“", `"`, "”", `"`, "″", `"`, "‘", `'`, "’", `'`).Replace(shortcode)
+	attrs := map[string]string{}
+	for _, m := range shortcodeAttrRe.FindAllStringSubmatch(shortcode, -1) {
+		attrs[strings.ToLower(m[1])] = m[2] + m[3] + m[4]
+	}
+	return attrs
+}
+
+// galleryImage copies an image used in a gallery, returning the url to use for it
+func galleryImage(u string, dir string, sourceUrl *url.URL) string {
+	asset := copyImage(u, sourceUrl)
+	if asset == nil {
+		return u
+	}
+	return fetchAsset(asset, dir)
+}
+
+// replaceNode swaps out a node for some raw markup
+func replaceNode(node *html.Node, markup string) {
+	node.Parent.InsertBefore(&html.Node{Type: html.RawNode, Data: markup}, node)
+	node.Parent.RemoveChild(node)
+}
+
+func getAttr(node *html.Node, key string) string {
+	for _, attr := range node.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func hasClass(node *html.Node, class string) bool {
+	for _, c := range strings.Fields(getAttr(node, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// findElements returns all the descendants of node with any of the given tags
+func findElements(node *html.Node, tags ...string) []*html.Node {
+	found := []*html.Node{}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode {
+			for _, tag := range tags {
+				if child.Data == tag {
+					found = append(found, child)
+					break
+				}
+			}
+		}
+		found = append(found, findElements(child, tags...)...)
+	}
+	return found
+}
+
+func textContent(node *html.Node) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(node)
+	return strings.TrimSpace(b.String())
+}
+
+// htmlText returns the text content of a fragment of html
+func htmlText(fragment string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div})
+	if err != nil {
+		return fragment
+	}
+	var b strings.Builder
+	for _, n := range nodes {
+		b.WriteString(textContent(n))
+	}
+	return b.String()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"maps"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestShortcodeAttrs(t *testing.T) {
+	tests := []struct {
+		shortcode string
+		want      map[string]string
+	}{
+		{`[gallery]`, map[string]string{}},
+		{`[gallery ids="1,2,3"]`, map[string]string{"ids": "1,2,3"}},
+		{`[gallery ids='4,5' columns=2 link="file"]`, map[string]string{"ids": "4,5", "columns": "2", "link": "file"}},
+		{`[gallery IDS = "6"]`, map[string]string{"ids": "6"}},
+		{`[gallery ids=“7,8” link=‘none’]`, map[string]string{"ids": "7,8", "link": "none"}},
+		{`[gallery ids=″9″]`, map[string]string{"ids": "9"}},
+		{`[gallery ids="" size="large"]`, map[string]string{"ids": "", "size": "large"}},
+	}
+	for _, tt := range tests {
+		got := shortcodeAttrs(tt.shortcode)
+		if !maps.Equal(got, tt.want) {
+			t.Errorf("shortcodeAttrs(%q) = %v, want %v", tt.shortcode, got, tt.want)
+		}
+	}
+}
+
+func TestGalleryShortcodeRe(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{`before [gallery] after`, `[gallery]`},
+		{`[gallery ids="1,2"]`, `[gallery ids="1,2"]`},
+		{`[galleryx]`, ``},
+		{`[gallery-of-things]`, ``},
+		{`no shortcode here`, ``},
+	}
+	for _, tt := range tests {
+		if got := galleryShortcodeRe.FindString(tt.text); got != tt.want {
+			t.Errorf("galleryShortcodeRe.FindString(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// firstElement parses a fragment of html and returns its first element
+func firstElement(t *testing.T, fragment string) *html.Node {
+	t.Helper()
+	doc, err := html.Parse(strings.NewReader(fragment))
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", fragment, err)
+	}
+	body := findBody(doc)
+	for child := body.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode {
+			return child
+		}
+	}
+	t.Fatalf("no element in %q", fragment)
+	return nil
+}
+
+func TestIsClassicGallery(t *testing.T) {
+	tests := []struct {
+		markup string
+		want   bool
+	}{
+		{`<div class="gallery galleryid-5 gallery-columns-3"><figure class="gallery-item"></figure></div>`, true},
+		{`<div class="gallery gallery-columns-2"></div>`, true},
+		{`<div class="gallery galleryid-12"></div>`, true},
+		{`<div class="gallery"><dl class="gallery-item"></dl></div>`, true},
+		{`<div class="gallery"><p>A theme's gallery</p></div>`, false},
+		{`<div class="gallery theme-thing"></div>`, false},
+		{`<div class="gallery-columns-3"></div>`, false},
+		{`<div class="photo-gallery galleryid-5"></div>`, false},
+	}
+	for _, tt := range tests {
+		if got := isClassicGallery(firstElement(t, tt.markup)); got != tt.want {
+			t.Errorf("isClassicGallery(%s) = %v, want %v", tt.markup, got, tt.want)
+		}
+	}
+}
+
+func TestGalleryHTML(t *testing.T) {
+	tests := []struct {
+		gallery Gallery
+		want    string
+	}{
+		{
+			Gallery{ID: "gallery-1"},
+			`<div class="gallery" id="gallery-1"></div>`,
+		},
+		{
+			Gallery{ID: "gallery-2", Caption: "Trip & more", Images: []GalleryImage{
+				{File: "a-150x150.jpg", Full: "a.jpg", Alt: "A", Caption: "First"},
+				{File: "b.jpg", Link: "/2020/01/post/"},
+				{File: "c.jpg"},
+			}},
+			`<div class="gallery" id="gallery-2">` +
+				`<figure><a href="a.jpg"><img src="a-150x150.jpg" alt="A"></a><figcaption>First</figcaption></figure>` +
+				`<figure><a href="/2020/01/post/"><img src="b.jpg" alt=""></a></figure>` +
+				`<figure><img src="c.jpg" alt=""></figure>` +
+				`<p>Trip &amp; more</p></div>`,
+		},
+	}
+	for _, tt := range tests {
+		if got := galleryHTML(&tt.gallery); got != tt.want {
+			t.Errorf("galleryHTML(%s) =\n%s\nwant\n%s", tt.gallery.ID, got, tt.want)
+		}
+	}
+}
+
+func TestHtmlText(t *testing.T) {
+	tests := []struct {
+		fragment string
+		want     string
+	}{
+		{``, ``},
+		{`<p>Caption A</p>`, `Caption A`},
+		{`<p>Fish &amp; <em>chips</em></p>`, `Fish & chips`},
+		{`  plain  `, `plain`},
+	}
+	for _, tt := range tests {
+		if got := htmlText(tt.fragment); got != tt.want {
+			t.Errorf("htmlText(%q) = %q, want %q", tt.fragment, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var languageLayouts = []string{"tree", "suffix"}
+
+// setLanguages works out the language of each post, and which posts are
+// translations of each other, from the fields Polylang and WPML add
+func setLanguages(posts []Post) {
+	languages := map[string]int{}
+	for i := range posts {
+		p := &posts[i]
+		ids := []int{p.ID}
+		if lang, ok := p.Extra["lang"].(string); ok && lang != "" {
+			// Polylang
+			p.Language = lang
+			if translations, ok := p.Extra["translations"].(map[string]interface{}); ok {
+				for _, v := range translations {
+					if id, ok := v.(float64); ok {
+						ids = append(ids, int(id))
+					}
+				}
+			}
+		} else if locale, ok := p.Extra["wpml_current_locale"].(string); ok && locale != "" {
+			// WPML
+			p.Language = localeLanguage(locale)
+			if translations, ok := p.Extra["wpml_translations"].([]interface{}); ok {
+				for _, v := range translations {
+					t, ok := v.(map[string]interface{})
+					if !ok {
+						continue
+					}
+					if id, ok := t["id"].(float64); ok {
+						ids = append(ids, int(id))
+					}
+				}
+			}
+		}
+		if p.Language == "" {
+			continue
+		}
+		languages[p.Language]++
+		p.TranslationKey = fmt.Sprintf("%s-%d", p.Type, slices.Min(ids))
+	}
+	for _, lang := range slices.Sorted(maps.Keys(languages)) {
+		info("Found %d posts in %s", languages[lang], lang)
+	}
+}
+
+// localeLanguage turns a WordPress locale, like fr_FR, into a language code
+func localeLanguage(locale string) string {
+	lang, _, _ := strings.Cut(locale, "_")
+	return strings.ToLower(lang)
+}
+
+// languagePath moves a post into the tree for its language, if we're saving
+// a tree per language
+func languagePath(p Post, dir []string) []string {
+	if p.Language == "" || languageLayout != "tree" {
+		return dir
+	}
+	return append([]string{p.Language}, stripLanguage(p, dir)...)
+}
+
+func stripLanguage(p Post, dir []string) []string {
+	if len(dir) > 0 && dir[0] == p.Language {
+		return dir[1:]
+	}
+	return dir
+}
+
+// postOutput is the directory and filename we save a post to. Posts in a
+// language go into their own tree, or get the language in their filename
+// alongside their translations.
+func postOutput(p Post) (string, string) {
+	dir := languagePath(p, postDirectory(p))
+	if p.Language != "" && languageLayout == "suffix" {
+		dir = stripLanguage(p, dir)
+	}
+	return filepath.Join(append([]string{dest}, dir...)...), languageFilename(p.Language, postFilename)
+}
+
+// languageFilename adds a language to a filename, like index.fr.md, when
+// translations share a directory
+func languageFilename(lang string, filename string) string {
+	if lang == "" || languageLayout != "suffix" {
+		return filename
+	}
+	ext := path.Ext(filename)
+	return strings.TrimSuffix(filename, ext) + "." + lang + ext
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSetLanguages(t *testing.T) {
+	posts := []Post{
+		{ID: 10, Type: "post", Extra: map[string]interface{}{"lang": "en", "translations": map[string]interface{}{"en": 10.0, "fr": 12.0}}},
+		{ID: 12, Type: "post", Extra: map[string]interface{}{"lang": "fr", "translations": map[string]interface{}{"en": 10.0, "fr": 12.0}}},
+		{ID: 20, Type: "page", Extra: map[string]interface{}{"wpml_current_locale": "de_DE", "wpml_translations": []interface{}{
+			map[string]interface{}{"id": 18.0, "locale": "en_US"},
+			"junk",
+		}}},
+		{ID: 30, Type: "post"},
+	}
+	setLanguages(posts)
+	tests := []struct {
+		language string
+		key      string
+	}{
+		{"en", "post-10"},
+		{"fr", "post-10"},
+		{"de", "page-18"},
+		{"", ""},
+	}
+	for i, tt := range tests {
+		if posts[i].Language != tt.language || posts[i].TranslationKey != tt.key {
+			t.Errorf("post %d is in %q with key %q, want %q with key %q",
+				posts[i].ID, posts[i].Language, posts[i].TranslationKey, tt.language, tt.key)
+		}
+	}
+}
+
+func TestLocaleLanguage(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{"fr_FR", "fr"},
+		{"pt_BR", "pt"},
+		{"EN", "en"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := localeLanguage(tt.locale); got != tt.want {
+			t.Errorf("localeLanguage(%q) = %q, want %q", tt.locale, got, tt.want)
+		}
+	}
+}
+
+func TestLanguagePath(t *testing.T) {
+	tests := []struct {
+		layout string
+		lang   string
+		dir    []string
+		want   []string
+	}{
+		{"tree", "", []string{"2020", "post"}, []string{"2020", "post"}},
+		{"tree", "fr", []string{"2020", "post"}, []string{"fr", "2020", "post"}},
+		{"tree", "fr", []string{"fr", "2020", "post"}, []string{"fr", "2020", "post"}},
+		{"suffix", "fr", []string{"fr", "2020", "post"}, []string{"fr", "2020", "post"}},
+	}
+	for _, tt := range tests {
+		setFor(t, &languageLayout, tt.layout)
+		if got := languagePath(Post{Language: tt.lang}, tt.dir); !slices.Equal(got, tt.want) {
+			t.Errorf("languagePath(%q, %q) with %s layout = %q, want %q", tt.lang, tt.dir, tt.layout, got, tt.want)
+		}
+	}
+}
+
+func TestLanguageFilename(t *testing.T) {
+	tests := []struct {
+		layout   string
+		lang     string
+		filename string
+		want     string
+	}{
+		{"suffix", "fr", "index.md", "index.fr.md"},
+		{"suffix", "fr", "gallery-1.yml", "gallery-1.fr.yml"},
+		{"suffix", "", "index.md", "index.md"},
+		{"tree", "fr", "index.md", "index.md"},
+	}
+	for _, tt := range tests {
+		setFor(t, &languageLayout, tt.layout)
+		if got := languageFilename(tt.lang, tt.filename); got != tt.want {
+			t.Errorf("languageFilename(%q, %q) with %s layout = %q, want %q", tt.lang, tt.filename, tt.layout, got, tt.want)
+		}
+	}
+}
+
+func TestPostOutput(t *testing.T) {
+	setFor(t, &dest, "out")
+	setFor(t, &prefix, "")
+	setFor(t, &sitePath, "")
+	setFor(t, &postFilename, "index.md")
+	tests := []struct {
+		layout   string
+		link     string
+		lang     string
+		dir      string
+		filename string
+	}{
+		{"tree", "https://example.com/2020/01/post/", "", "out/2020/01/post", "index.md"},
+		{"tree", "https://example.com/fr/2020/01/post/", "fr", "out/fr/2020/01/post", "index.md"},
+		{"tree", "https://example.com/2020/01/post/", "fr", "out/fr/2020/01/post", "index.md"},
+		{"suffix", "https://example.com/fr/2020/01/post/", "fr", "out/2020/01/post", "index.fr.md"},
+	}
+	for _, tt := range tests {
+		setFor(t, &languageLayout, tt.layout)
+		dir, filename := postOutput(Post{Link: tt.link, Language: tt.lang})
+		if dir != filepath.FromSlash(tt.dir) || filename != tt.filename {
+			t.Errorf("postOutput(%s in %q) with %s layout = %s, %s, want %s, %s",
+				tt.link, tt.lang, tt.layout, dir, filename, tt.dir, tt.filename)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// LinkTarget is a post or page we know about, and where it now lives
+type LinkTarget struct {
+	ID       int
+	Link     string
+	Path     string
+	Exported bool
+}
+
+// LinkIndex finds posts and pages from any of the urls WordPress uses for them
+type LinkIndex struct {
+	byLink map[string]*LinkTarget
+	byID   map[int]*LinkTarget
+	bySlug map[string]*LinkTarget
+	// The shapes of the permalinks of the posts we know about
+	shapes map[string]bool
+}
+
+var linkIndex = newLinkIndex()
+
+func newLinkIndex() *LinkIndex {
+	return &LinkIndex{
+		byLink: map[string]*LinkTarget{},
+		byID:   map[int]*LinkTarget{},
+		bySlug: map[string]*LinkTarget{},
+		shapes: map[string]bool{},
+	}
+}
+
+func (li *LinkIndex) Add(p Post, exported bool) {
+	t := &LinkTarget{
+		ID:       p.ID,
+		Link:     p.Link,
+		Path:     postUrlPath(p),
+		Exported: exported,
+	}
+	li.byID[p.ID] = t
+	li.byLink[normaliseLink(p.Link)] = t
+	if p.JetpackShortlink != "" {
+		li.byLink[normaliseLink(p.JetpackShortlink)] = t
+	}
+	if _, ok := li.bySlug[p.Slug]; !ok && p.Slug != "" {
+		li.bySlug[p.Slug] = t
+	}
+	if u, err := url.Parse(p.Link); err == nil {
+		li.shapes[permalinkShape(u.Path)] = true
+	}
+}
+
+// permalinkShape is the structure of a permalink, like "#/#/*" for
+// /2020/01/some-post/, with numbers and the final slug wildcarded
+func permalinkShape(p string) string {
+	parts := strings.FieldsFunc(p, func(c rune) bool { return c == '/' })
+	for i, part := range parts {
+		if _, err := strconv.Atoi(part); err == nil {
+			parts[i] = "#"
+		} else if i == len(parts)-1 {
+			parts[i] = "*"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// LooksLikePost is whether a url we don't know has the shape of the
+// permalink of a post, rather than an archive or something else. Only
+// permalinks with dates or IDs in them are distinctive enough to tell, as a
+// single slug could be a page, a feed or a plugin's page just as well.
+func (li *LinkIndex) LooksLikePost(u *url.URL) bool {
+	if other := networkIndex(u); other != nil && other != li {
+		return other.LooksLikePost(u)
+	}
+	q := u.Query()
+	if q.Get("p") != "" || q.Get("page_id") != "" {
+		return true
+	}
+	shape := permalinkShape(u.Path)
+	return strings.Contains(shape, "#") && li.shapes[shape]
+}
+
+// Lookup finds the post or page an absolute url refers to
+func (li *LinkIndex) Lookup(u *url.URL) *LinkTarget {
+	// Links to other sites of a network are in their own index
+	if other := networkIndex(u); other != nil && other != li {
+		return other.Lookup(u)
+	}
+	if t, ok := li.byLink[normaliseLink(u.String())]; ok {
+		return t
+	}
+	if isShortlink(u) || !sameSite(u) {
+		return nil
+	}
+	q := u.Query()
+	for _, key := range []string{"p", "page_id"} {
+		if q.Get(key) != "" {
+			id, err := strconv.Atoi(q.Get(key))
+			if err == nil {
+				return li.byID[id]
+			}
+		}
+	}
+	parts := strings.FieldsFunc(u.Path, func(c rune) bool { return c == '/' })
+	if len(parts) == 1 {
+		return li.bySlug[parts[0]]
+	}
+	return nil
+}
+
+func (li *LinkIndex) ByID(id int) *LinkTarget {
+	return li.byID[id]
+}
+
+func isShortlink(u *url.URL) bool {
+	return strings.ToLower(u.Hostname()) == "wp.me"
+}
+
+// rewriteLink returns the new url for a link to one of our posts or pages,
+// as seen from the page at fromPath, or "" if it's not a link we know of
+func rewriteLink(href string, sourceUrl *url.URL, fromPath string) string {
+	if linkStyle == "none" {
+		return ""
+	}
+	hu, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return ""
+	}
+	ref := sourceUrl.ResolveReference(hu)
+	if ref.Scheme != "http" && ref.Scheme != "https" {
+		return ""
+	}
+	if !sameSite(ref) && !isShortlink(ref) {
+		return ""
+	}
+	t := linkIndex.Lookup(ref)
+	if t == nil {
+		// With --sample we don't know about most posts, so can't tell
+		if sample == 0 && linkIndex.LooksLikePost(ref) {
+			brokenLink(ref.String(), "no such post or page")
+		}
+		return ""
+	}
+	if !t.Exported {
+		brokenLink(ref.String(), "not exported")
+		return ""
+	}
+	newUrl := t.Path
+	if linkStyle == "relative" {
+		newUrl = relativeUrlPath(fromPath, t.Path)
+	}
+	if ref.Fragment != "" {
+		newUrl += "#" + ref.Fragment
+	}
+	return newUrl
+}
+
+func brokenLink(u string, reason string) {
+	errorList.Links = append(errorList.Links, BrokenLink{
+		Page:   logContext.PostLink,
+		URL:    u,
+		Reason: reason,
+	})
+}
+
+// relativeUrlPath gives a relative url from one directory-style path to another
+func relativeUrlPath(from string, to string) string {
+	split := func(s string) []string {
+		return strings.FieldsFunc(s, func(c rune) bool { return c == '/' })
+	}
+	fromParts := split(from)
+	toParts := split(to)
+	common := 0
+	for common < len(fromParts) && common < len(toParts) && fromParts[common] == toParts[common] {
+		common++
+	}
+	rel := strings.Repeat("../", len(fromParts)-common) + strings.Join(toParts[common:], "/")
+	if rel == "" {
+		return "./"
+	}
+	if common < len(toParts) && strings.HasSuffix(to, "/") {
+		rel += "/"
+	}
+	return rel
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestRelativeUrlPath(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want string
+	}{
+		{"/2020/01/first/", "/2020/01/second/", "../second/"},
+		{"/2020/01/first/", "/2021/05/other/", "../../../2021/05/other/"},
+		{"/2020/01/first/", "/2020/01/first/", "./"},
+		{"/about/", "/", "../"},
+		{"/", "/about/", "about/"},
+		{"/2020/01/first/", "/2020/01/first/photo.jpg", "photo.jpg"},
+		{"/a/b/", "/a/b/c/", "c/"},
+	}
+	for _, tt := range tests {
+		if got := relativeUrlPath(tt.from, tt.to); got != tt.want {
+			t.Errorf("relativeUrlPath(%q, %q) = %q, want %q", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestPermalinkShape(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", ""},
+		{"/about/", "*"},
+		{"/2020/01/some-post/", "#/#/*"},
+		{"/2020/01/", "#/#"},
+		{"/archives/123", "archives/#"},
+		{"/blog/2020/some-post/", "blog/#/*"},
+		{"/category/news/", "category/*"},
+	}
+	for _, tt := range tests {
+		if got := permalinkShape(tt.path); got != tt.want {
+			t.Errorf("permalinkShape(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+// testLinkIndex knows about a post, a page and a post left out by --filter
+func testLinkIndex(t *testing.T) *LinkIndex {
+	t.Helper()
+	setFor(t, &apiUrl, "https://example.com/wp-json/")
+	setFor(t, &prefix, "")
+	setFor(t, &sitePrefix, "")
+	setFor(t, &sitePath, "")
+	setFor(t, &languageLayout, "tree")
+	li := newLinkIndex()
+	li.Add(Post{ID: 5, Slug: "first", Link: "https://example.com/2020/01/first/", JetpackShortlink: "https://wp.me/p1-5"}, true)
+	li.Add(Post{ID: 7, Slug: "about", Link: "https://example.com/about/"}, true)
+	li.Add(Post{ID: 9, Slug: "hidden", Link: "https://example.com/2020/02/hidden/"}, false)
+	return li
+}
+
+func TestLinkIndexLookup(t *testing.T) {
+	li := testLinkIndex(t)
+	tests := []struct {
+		link string
+		want int
+	}{
+		{"https://example.com/2020/01/first/", 5},
+		{"http://www.example.com/2020/01/first", 5},
+		{"https://example.com/2020/01/first/?utm_source=feed", 5},
+		{"https://example.com/?p=5", 5},
+		{"https://example.com/?page_id=7", 7},
+		{"https://wp.me/p1-5", 5},
+		{"https://example.com/first/", 5},
+		{"https://example.com/about/", 7},
+		{"https://example.com/2020/02/hidden/", 9},
+		{"https://example.com/2020/03/gone/", 0},
+		{"https://example.com/?p=99", 0},
+		{"https://elsewhere.example/first/", 0},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.link)
+		got := 0
+		if target := li.Lookup(u); target != nil {
+			got = target.ID
+		}
+		if got != tt.want {
+			t.Errorf("Lookup(%q) = post %d, want %d", tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestLooksLikePost(t *testing.T) {
+	li := testLinkIndex(t)
+	tests := []struct {
+		link string
+		want bool
+	}{
+		{"https://example.com/2020/03/gone/", true},
+		{"https://example.com/?p=99", true},
+		{"https://example.com/?page_id=99", true},
+		{"https://example.com/feed/", false},
+		{"https://example.com/contact/", false},
+		{"https://example.com/2020/03/", false},
+		{"https://example.com/category/news/", false},
+		{"https://example.com/", false},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.link)
+		if got := li.LooksLikePost(u); got != tt.want {
+			t.Errorf("LooksLikePost(%q) = %v, want %v", tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteLink(t *testing.T) {
+	setFor(t, &linkIndex, testLinkIndex(t))
+	setFor(t, &sample, 0)
+	setFor(t, &errorList, Errors{})
+	source, _ := url.Parse("https://example.com/2020/01/first/")
+	tests := []struct {
+		style  string
+		href   string
+		want   string
+		broken string
+	}{
+		{"root", "/about/", "/about/", ""},
+		{"root", "https://example.com/?p=5#comments", "/2020/01/first/#comments", ""},
+		{"relative", "/about/", "../../../about/", ""},
+		{"none", "/about/", "", ""},
+		{"root", "https://elsewhere.example/about/", "", ""},
+		{"root", "mailto:someone@example.com", "", ""},
+		{"root", "/feed/", "", ""},
+		{"root", "/2020/03/gone/", "", "no such post or page"},
+		{"root", "/2020/02/hidden/", "", "not exported"},
+	}
+	for _, tt := range tests {
+		setFor(t, &linkStyle, tt.style)
+		errorList = Errors{}
+		if got := rewriteLink(tt.href, source, "/2020/01/first/"); got != tt.want {
+			t.Errorf("rewriteLink(%q) with %s links = %q, want %q", tt.href, tt.style, got, tt.want)
+		}
+		broken := ""
+		if len(errorList.Links) > 0 {
+			broken = errorList.Links[0].Reason
+		}
+		if broken != tt.broken {
+			t.Errorf("rewriteLink(%q) reported %q, want %q", tt.href, broken, tt.broken)
+		}
+	}
+}
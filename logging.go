@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+)
+
+var logFormats = []string{"text", "json"}
+
+// logger gets everything we report as structured events, for --log and
+// --log-format. It's all in package main, so setLogHandler is for code
+// built in here rather than for anyone importing us.
+var logger = slog.New(discardHandler{})
+
+func setLogHandler(h slog.Handler) {
+	logger = slog.New(h)
+}
+
+// newLogHandler writes events to w as text or as one JSON object per line
+func newLogHandler(w io.Writer, format string) slog.Handler {
+	if format == "json" {
+		return slog.NewJSONHandler(w, nil)
+	}
+	return slog.NewTextHandler(w, nil)
+}
+
+// LogContext is what we're working on, which is attached to everything we
+// log while we're working on it
+type LogContext struct {
+	PostID   int
+	PostLink string
+	Language string
+}
+
+var logContext LogContext
+
+// workingOn sets the post, or other page, we're working on. Pass an empty
+// LogContext when we're done with it.
+func workingOn(c LogContext) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	logContext = c
+}
+
+func (c LogContext) attrs() []slog.Attr {
+	attrs := []slog.Attr{}
+	if c.PostID != 0 {
+		attrs = append(attrs, slog.Int("post_id", c.PostID))
+	}
+	if c.PostLink != "" {
+		attrs = append(attrs, slog.String("post_link", c.PostLink))
+	}
+	if c.Language != "" {
+		attrs = append(attrs, slog.String("lang", c.Language))
+	}
+	return attrs
+}
+
+// logEventLocked logs msg with the post we're working on and any other attributes.
+// The caller holds outputMu.
+func logEventLocked(level slog.Level, msg string, attrs ...slog.Attr) {
+	logger.LogAttrs(context.Background(), level, msg, append(logContext.attrs(), attrs...)...)
+}
+
+func logEvent(level slog.Level, msg string, attrs ...slog.Attr) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	logEventLocked(level, msg, attrs...)
+}
+
+// logFetch records an http request we made
+func logFetch(u string, status int, took time.Duration, err error) {
+	if err != nil {
+		logEvent(slog.LevelWarn, "fetch failed", slog.String("url", u), slog.Duration("duration", took), slog.String("error", err.Error()))
+		return
+	}
+	logEvent(slog.LevelInfo, "fetched", slog.String("url", u), slog.Int("status", status), slog.Duration("duration", took))
+}
+
+// discardHandler drops everything, for when we're not logging
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
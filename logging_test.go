@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogContextAttrs(t *testing.T) {
+	tests := []struct {
+		context LogContext
+		want    []slog.Attr
+	}{
+		{LogContext{}, []slog.Attr{}},
+		{LogContext{PostLink: "https://example.com/category/news/"},
+			[]slog.Attr{slog.String("post_link", "https://example.com/category/news/")}},
+		{LogContext{PostID: 5, PostLink: "https://example.com/fr/post/", Language: "fr"}, []slog.Attr{
+			slog.Int("post_id", 5),
+			slog.String("post_link", "https://example.com/fr/post/"),
+			slog.String("lang", "fr"),
+		}},
+	}
+	for _, tt := range tests {
+		if got := tt.context.attrs(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v.attrs() = %v, want %v", tt.context, got, tt.want)
+		}
+	}
+}
+
+// logTo sends our events to a buffer for the rest of a test
+func logTo(t *testing.T, format string) *bytes.Buffer {
+	t.Helper()
+	var buff bytes.Buffer
+	setFor(t, &logger, logger)
+	setLogHandler(newLogHandler(&buff, format))
+	return &buff
+}
+
+func TestLogEventsAsJSON(t *testing.T) {
+	buff := logTo(t, "json")
+	setFor(t, &quiet, true)
+	setFor(t, &logContext, LogContext{})
+	workingOn(LogContext{PostID: 5, PostLink: "https://example.com/post/"})
+	warn("Something odd about %s", "this post")
+	workingOn(LogContext{})
+	logFetch("https://example.com/wp-json/", 200, 1500*time.Millisecond, nil)
+	logFetch("https://example.com/gone.jpg", 0, time.Second, errors.New("connection refused"))
+
+	want := []map[string]any{
+		{"level": "WARN", "msg": "Something odd about this post", "post_id": 5.0, "post_link": "https://example.com/post/"},
+		{"level": "INFO", "msg": "fetched", "url": "https://example.com/wp-json/", "status": 200.0, "duration": 1.5e9},
+		{"level": "WARN", "msg": "fetch failed", "url": "https://example.com/gone.jpg", "duration": 1e9, "error": "connection refused"},
+	}
+	lines := strings.Split(strings.TrimSpace(buff.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("logged %d events, want %d:\n%s", len(lines), len(want), buff)
+	}
+	for i, line := range lines {
+		var got map[string]any
+		err := json.Unmarshal([]byte(line), &got)
+		if err != nil {
+			t.Fatalf("event %d isn't json: %v\n%s", i, err, line)
+		}
+		delete(got, "time")
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("event %d = %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestLogEventsAsText(t *testing.T) {
+	buff := logTo(t, "text")
+	setFor(t, &logContext, LogContext{Language: "de"})
+	logEvent(slog.LevelInfo, "saved", slog.String("file", "index.de.md"))
+	want := `level=INFO msg=saved lang=de file=index.de.md`
+	if got := buff.String(); !strings.Contains(got, want) {
+		t.Errorf("logged %q, want it to contain %q", got, want)
+	}
+}
+
+func TestDiscardHandler(t *testing.T) {
+	var h slog.Handler = discardHandler{}
+	if h.Enabled(context.Background(), slog.LevelError) {
+		t.Errorf("discardHandler is enabled")
+	}
+	if h.WithAttrs([]slog.Attr{slog.Int("a", 1)}) != h || h.WithGroup("g") != h {
+		t.Errorf("discardHandler doesn't stay a discardHandler")
+	}
+}
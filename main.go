@@ -8,6 +8,9 @@ import (
 	"fmt"
 	"golang.org/x/net/publicsuffix"
 	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
 	"mime"
 	"net/http"
 	"net/url"
@@ -15,8 +18,10 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/net/html"
@@ -33,6 +38,10 @@ var dest string
 var saveMeta bool
 var prefix string
 var logFile string
+var logFormat string
+var keepGoing bool
+var resume bool
+var onlyIDs []string
 var wpUploads string
 var silent bool
 var quiet bool
@@ -46,6 +55,36 @@ var cacheDir string
 var stale bool
 var mirror bool
 var userAgent string
+var targetName string
+var galleryMode string
+var auth string
+var menuFormat string
+var redirectList []string
+var exportPages bool
+var linkStyle string
+var auditMode bool
+var checkExternal bool
+var concurrency int
+var commentFormat string
+var siteUrl string
+var remark42Site string
+var commentsPerPost bool
+var pingbacks string
+var commentPrivacy string
+var allowContactFile string
+var mirrorAvatars bool
+var avatarSize int
+var saveAuthors bool
+var taxonomyKeys map[string]string
+var saveCategories bool
+var fieldsFile string
+var exportSEO bool
+var languages []string
+var languageLayout string
+var networkMode bool
+var siteList []string
+var rateLimit float64
+var dateZone string
 
 const myName = "wordpress-export"
 const version = "0.2"
@@ -58,6 +97,7 @@ func init() {
 	flag.StringVarP(&dest, "output", "o", "./output", "Save results to this directory")
 	flag.StringVar(&prefix, "prefix", "", "Strip this prefix off post paths")
 	flag.StringVar(&logFile, "log", "", "Log progress to this file")
+	flag.StringVar(&logFormat, "log-format", "text", "Log as text, or json with one object per line (to stderr, without --log)")
 	flag.StringVar(&wpUploads, "assets", "/wp-content/uploads/", "Copy assets under this path")
 	flag.BoolVarP(&quiet, "quiet", "q", false, "Don't print progress")
 	flag.BoolVar(&silent, "silent", false, "Don't print progress or warnings")
@@ -69,6 +109,40 @@ func init() {
 	flag.BoolVar(&stale, "stale", false, "Do not expire cached results")
 	flag.BoolVar(&mirror, "mirror", false, "Mirror remote images")
 	flag.StringVar(&userAgent, "user-agent", "Mozilla/5.0 (X11; Linux x86_64; rv:60.0) Gecko/20100101 Firefox/81.0", "Override request user-agent")
+	flag.StringVar(&targetName, "target", "generic", "Write output for this site generator ("+targetNames()+")")
+	flag.StringVar(&galleryMode, "galleries", "frontmatter", "Save galleries in frontmatter, in files, or none")
+	flag.StringVar(&auth, "auth", "", "Authenticate as user:application-password")
+	flag.StringVar(&menuFormat, "menus", "", "Save navigation menus as yaml or json")
+	flag.StringSliceVar(&redirectList, "redirects", nil, "Write redirects from old urls in these formats (apache, hugo, netlify, nginx, vercel)")
+	flag.BoolVar(&exportPages, "pages", false, "Export pages as well as posts")
+	flag.StringVar(&linkStyle, "links", "root", "Rewrite links between posts to be root, relative or none")
+	flag.BoolVar(&auditMode, "audit", false, "Check links, images and iframes in every post instead of exporting them")
+	flag.BoolVar(&checkExternal, "check-external", false, "Check links to other sites when auditing")
+	flag.IntVar(&concurrency, "concurrency", 8, "Make this many requests at once when fetching listings or checking links")
+	flag.StringVar(&commentFormat, "comments-format", "json", "Save comments as "+strings.Join(commentFormats, ", "))
+	flag.StringVar(&siteUrl, "site-url", "", "URL of the new site, for comment systems that need absolute links")
+	flag.StringVar(&remark42Site, "remark42-site", "remark", "Site ID to use for Remark42 comments")
+	flag.BoolVar(&commentsPerPost, "comments-per-post", false, "Fetch comments for each post as it's exported, rather than all at once")
+	flag.StringVar(&pingbacks, "pingbacks", "drop", "Pingbacks and trackbacks can be kept with comments, separate or dropped")
+	flag.StringVar(&commentPrivacy, "comment-privacy", "safe", "Remove personal details from comments: none, safe (hash emails, drop IPs and user agents) or anonymous (also hide names, urls and avatars)")
+	flag.StringVar(&allowContactFile, "allow-contact", "", "Keep the emails, names and urls of commenters with emails listed in this file")
+	flag.StringVar(&anonymousSalt, "anonymous-salt", "", "Mix this secret into the IDs of anonymous commenters, to keep them the same between exports (default random)")
+	flag.BoolVar(&mirrorAvatars, "avatars", false, "Copy avatars of commenters and authors, rather than linking to gravatar")
+	flag.IntVar(&avatarSize, "avatar-size", 96, "Size of avatar to copy")
+	flag.BoolVar(&saveAuthors, "authors", false, "Save author profiles and a landing page for each author")
+	flag.StringToStringVar(&taxonomyKeys, "taxonomy-keys", nil, "Save custom taxonomies under these frontmatter keys, as taxonomy=key")
+	flag.BoolVar(&saveCategories, "categories", false, "Save a landing page for each category")
+	flag.StringVar(&fieldsFile, "fields", "", "Add ACF fields and post meta to the frontmatter, as described in this yaml file")
+	flag.BoolVar(&exportSEO, "seo", false, "Save SEO metadata from Yoast, Rank Math or All in One SEO")
+	flag.StringSliceVar(&languages, "languages", nil, "Fetch posts in each of these languages, for multilingual sites that only list one language at a time")
+	flag.StringVar(&languageLayout, "language-layout", "", "Save each language in its own tree, or with the language as a suffix on the filename (default suffix for hugo, otherwise tree)")
+	flag.BoolVar(&networkMode, "network", false, "Export every site of a multisite network, where the API lists them")
+	flag.StringSliceVar(&siteList, "sites", nil, "Export these sites of a multisite network, as urls or paths under the root site")
+	flag.Float64Var(&rateLimit, "rate", 0, "Make no more than this many requests a second")
+	flag.StringVar(&dateZone, "dates", "local", "Write dates in the site's local timezone, or utc")
+	flag.BoolVar(&keepGoing, "keep-going", false, "Carry on past posts with problems, and list them in failures.json")
+	flag.StringSliceVar(&onlyIDs, "only-ids", nil, "Only save the posts with these IDs, or site/ID for a network, such as those in failed-ids.txt")
+	flag.BoolVar(&resume, "resume", false, "Carry on from where an interrupted export stopped")
 	flag.BoolVarP(&showHelp, "help", "h", false, "Show this help")
 	flag.BoolVarP(&showVersion, "version", "V", false, "Show version")
 
@@ -91,13 +165,19 @@ type Warning struct {
 	Message string
 }
 
+type BrokenLink struct {
+	Page   string
+	URL    string
+	Reason string
+}
+
 type Errors struct {
 	Missing  []Missing
 	Warnings []Warning
+	Links    []BrokenLink
 }
 
 var errorList Errors
-var currentPage string
 
 func main() {
 	flag.Parse()
@@ -112,45 +192,108 @@ func main() {
 
 	// Set up log file and verbosity
 	var err error
+	if !slices.Contains(logFormats, logFormat) {
+		_, _ = fmt.Fprintf(os.Stderr, "Unknown log format '%s', try one of %s\n", logFormat, strings.Join(logFormats, ", "))
+		os.Exit(1)
+	}
 	if logFile != "" {
 		logWriter, err = os.Create(logFile)
 		if err != nil {
 			_, _ = fmt.Fprintf(os.Stderr, "Failed to open log file %s: %v\n", logFile, err)
 			os.Exit(1)
 		}
+	} else if logFormat == "json" {
+		logWriter = os.Stderr
+		// Keep stderr to one JSON object per line
+		progress.tty = false
+		progress.events = true
+	}
+	if logWriter != nil {
+		setLogHandler(newLogHandler(logWriter, logFormat))
 	}
 
 	quiet = quiet || silent
 
-	client = &http.Client{
-		Timeout: time.Second * 30,
+	target = targets[targetName]
+	if target == nil {
+		fatal("Unknown target '%s', try one of %s", targetName, targetNames())
 	}
-
-	if cacheDir != "" {
-		_ = os.MkdirAll(cacheDir, 0755)
+	if auth != "" && !strings.Contains(auth, ":") {
+		fatal("--auth should be username:application-password")
 	}
-
-	// Handle the parameter, which we hope is "a link to the site"
-	switch flag.NArg() {
+	switch menuFormat {
+	case "", "yaml", "json":
 	default:
-		fatal("%s takes only one parameter, the url of the wordpress site", myName)
-	case 0:
-	case 1:
-		siteUrl, err := parseURL(flag.Arg(0))
-		if err != nil {
-			fatal("'%s' doesn't look like a url: %v", flag.Arg(0), err)
+		fatal("--menus must be yaml or json")
+	}
+	for _, format := range redirectList {
+		if _, ok := redirectFormats[format]; !ok {
+			fatal("Unknown redirect format '%s'", format)
 		}
-		if apiUrl == "" {
-			apiUrl = findApi(siteUrl)
+	}
+	if !slices.Contains(commentFormats, commentFormat) {
+		fatal("--comments-format must be one of %s", strings.Join(commentFormats, ", "))
+	}
+	switch pingbacks {
+	case "keep", "separate", "drop":
+	default:
+		fatal("--pingbacks must be one of keep, separate or drop")
+	}
+	if !slices.Contains(privacyPolicies, commentPrivacy) {
+		fatal("--comment-privacy must be one of %s", strings.Join(privacyPolicies, ", "))
+	}
+	if allowContactFile != "" {
+		readAllowContact(allowContactFile)
+	}
+	if anonymousSalt == "" {
+		anonymousSalt = randomSalt()
+	}
+	if fieldsFile != "" {
+		readFieldMapping(fieldsFile)
+	}
+	if languageLayout == "" {
+		languageLayout = "tree"
+		if target.Name == "hugo" {
+			languageLayout = "suffix"
+		}
+	}
+	if !slices.Contains(languageLayouts, languageLayout) {
+		fatal("--language-layout must be one of %s", strings.Join(languageLayouts, ", "))
+	}
+	for _, id := range onlyIDs {
+		if !postKeyRe.MatchString(id) {
+			fatal("--only-ids takes post IDs, or site/ID for a network, not '%s'", id)
+		}
+		// The sites of a network each number their posts from 1
+		if (networkMode || len(siteList) > 0) != strings.Contains(id, "/") {
+			fatal("--only-ids takes site/ID for a network, and just the ID otherwise, not '%s'", id)
 		}
 	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	switch linkStyle {
+	case "root", "relative", "none":
+	default:
+		fatal("--links must be one of root, relative or none")
+	}
+	switch dateZone {
+	case "local", "utc":
+	default:
+		fatal("--dates must be local or utc")
+	}
+	switch galleryMode {
+	case "frontmatter", "files", "none":
+	default:
+		fatal("--galleries must be one of frontmatter, files or none")
+	}
 
-	if apiUrl == "" {
-		fatal("I couldn't find the API of the site to export, try with '%s <url>' or with --api", myName)
+	client = &http.Client{
+		Timeout: time.Second * 30,
 	}
 
-	if !strings.HasSuffix(apiUrl, "/") {
-		apiUrl = apiUrl + "/"
+	if cacheDir != "" {
+		_ = os.MkdirAll(cacheDir, 0755)
 	}
 
 	frontmatter := ""
@@ -172,107 +315,282 @@ func main() {
 		fatal("Failed to compile filter: %v", err)
 	}
 
+	// Handle the parameter, which we hope is "a link to the site"
+	var rootUrl *url.URL
+	switch flag.NArg() {
+	default:
+		fatal("%s takes only one parameter, the url of the wordpress site", myName)
+	case 0:
+	case 1:
+		rootUrl, err = parseURL(flag.Arg(0))
+		if err != nil {
+			fatal("'%s' doesn't look like a url: %v", flag.Arg(0), err)
+		}
+	}
+
+	handleInterrupts()
+	if !auditMode {
+		checkpoint = openCheckpoint(filepath.Join(dest, ".checkpoint"))
+	}
+
+	problems := 0
+	if networkMode || len(siteList) > 0 {
+		if rootUrl == nil {
+			fatal("Give the url of the root site of the network to export with --network or --sites")
+		}
+		problems = exportNetwork(rootUrl, frontmatter, filterRe)
+	} else {
+		if apiUrl == "" && rootUrl != nil {
+			apiUrl = findApi(rootUrl)
+		}
+		if apiUrl == "" {
+			fatal("I couldn't find the API of the site to export, try with '%s <url>' or with --api", myName)
+		}
+		if !strings.HasSuffix(apiUrl, "/") {
+			apiUrl = apiUrl + "/"
+		}
+		site := &Site{Api: apiUrl, Dest: dest, links: linkIndex}
+		site.use()
+		info("Using API at %s", apiUrl)
+		loadSite(site, filterRe)
+		problems = exportSite(site, frontmatter)
+	}
+
+	checkpoint.finish()
+	progress.finish()
+	if auditMode {
+		if problems > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if len(errorList.Missing) > 0 {
+		warn("There were %d missing assets", len(errorList.Missing))
+	}
+	if len(errorList.Warnings) > 0 {
+		warn("There were %d warnings", len(errorList.Warnings))
+	}
+	if len(errorList.Links) > 0 {
+		warn("There were %d links to posts or pages that don't exist or weren't exported", len(errorList.Links))
+	}
+	if len(errorList.Missing) > 0 || len(errorList.Warnings) > 0 || len(errorList.Links) > 0 {
+		writeMeta("errors", errorList)
+	}
+	if len(failures) > 0 {
+		writeFailures()
+		os.Exit(1)
+	}
+}
+
+// loadSite finds all the posts of a site, and where they'll be on the new
+// site, so that we can rewrite links to them
+func loadSite(s *Site, filterRe *regexp.Regexp) {
 	_ = os.MkdirAll(dest, 0755)
+	s.index = getSiteIndex()
+	// WordPress may be in a directory of a single site, which its ?p= and
+	// other query urls are under
+	if s.URL == "" && s.index != nil && s.index.Home != "" {
+		siteHome = strings.TrimSuffix(oldPath(s.index.Home), "/") + "/"
+	}
+	s.location = siteTimezone(s.index)
+	siteLocation = s.location
+	s.taxonomies = getTaxonomies()
+	customTaxonomies = s.taxonomies
+	checkTaxonomyKeys()
+
+	s.posts = getPosts("posts")
+	if exportPages {
+		s.posts = append(s.posts, getPosts("pages")...)
+	}
+	setLanguages(s.posts)
+
+	for _, p := range s.posts {
+		match := filterRe.MatchString(p.Link)
+		if match {
+			s.exported = append(s.exported, p)
+		}
+		linkIndex.Add(p, match)
+	}
+}
 
-	info("Using API at %s", apiUrl)
+// exportSite saves everything from a site we've loaded, returning the number
+// of problems found if we're auditing
+func exportSite(s *Site, frontmatter string) int {
+	exported := s.exported
+	if auditMode {
+		return audit(exported)
+	}
+
+	writeSite(s.index)
 	users := getUsers()
 	categories := getCategories()
 	tags := getTags()
-	comments := getComments()
+	comments := map[int]*PostComments{}
+	perPostApproved := map[int][]Comment{}
+	if !commentsPerPost {
+		var err error
+		comments, err = getComments("")
+		if err != nil {
+			fatal("%v", err)
+		}
+	}
 	if saveMeta {
 		writeMeta("users", &users)
 		writeMeta("categories", &categories)
 		writeMeta("tags", &tags)
-		writeMeta("comments", &comments)
+		writeMeta("taxonomies", customTaxonomies)
+		if !commentsPerPost {
+			writeMeta("comments", approvedComments(comments))
+		}
 	}
-	posts := getPosts()
 
-	for _, p := range posts {
-		if !filterRe.MatchString(p.Link) {
-			continue
+	resolveBylines(exported, users)
+
+	aliases := map[int][]string{}
+	if len(redirectList) > 0 {
+		var redirects []Redirect
+		redirects, aliases = buildRedirects(exported, users, categories)
+		writeRedirects(redirects)
+		if !slices.Contains(redirectList, "hugo") {
+			aliases = map[int][]string{}
 		}
+	}
+
+	saving := slices.DeleteFunc(slices.Clone(exported), func(p Post) bool { return !selected(p) })
+	if len(onlyIDs) > 0 {
+		info("Saving %d of %d posts", len(saving), len(exported))
+	}
+	postsBar.AddTotal(len(saving))
+	for _, p := range saving {
+		workingOn(LogContext{PostID: p.ID, PostLink: p.Link, Language: p.Language})
+		p.Aliases = aliases[p.ID]
 		author, ok := users[p.Author]
 		if !ok {
-			fatal("No such author as %d in post %s", p.Author, p.Link)
+			postFailed("No such author as %d in post %s", p.Author, p.Link).MissingAuthor = p.Author
+			author = &User{Name: "unknown"}
 		}
 		p.AuthorName = author.Name
+		p.AuthorSlug = author.Slug
 
 		catNames := []string{}
 		for _, category := range p.Categories {
 			cat, ok := categories[category]
 			if !ok {
-				fatal("No such category as %d in post %s", category, p.Link)
+				f := postFailed("No such category as %d in post %s", category, p.Link)
+				f.MissingCategories = append(f.MissingCategories, category)
+				continue
 			}
 			catNames = append(catNames, cat.Name)
 		}
 		p.CategoryNames = catNames
+		p.CategoryPaths = categoryPaths(p.Categories, categories)
 
 		tagNames := []string{}
 		for _, tag := range p.Tags {
 			t, ok := tags[tag]
 			if !ok {
-				fatal("No such tag as %d in post %s", tag, p.Link)
+				f := postFailed("No such tag as %d in post %s", tag, p.Link)
+				f.MissingTags = append(f.MissingTags, tag)
+				continue
 			}
 			tagNames = append(tagNames, t.Name)
 		}
 		p.TagNames = tagNames
-		savePost(p, frontmatter)
-		cm, ok := comments[p.ID]
-		if ok {
-			postPath := postDirectory(p)
-
-			// Where do we write the output for this post?
-			outputDir := filepath.Join(append([]string{dest}, postPath...)...)
-			commentFile, err := os.Create(filepath.Join(outputDir, "comments.json"))
+		if checkpoint.postDone(p) {
+			// Saved before we were interrupted, but the comments are needed
+			// for the site-wide comment files
+			logEvent(slog.LevelInfo, "already saved post")
+		} else {
+			start := time.Now()
+			err := savePost(p, frontmatter)
 			if err != nil {
-				fatal("Failed to create comments file: %v", err)
+				postFailed("Skipping %s: %v", p.Link, err).Skipped = true
+				postsBar.Add(1)
+				continue
 			}
-			enc := json.NewEncoder(commentFile)
-			enc.SetIndent("", "  ")
-			enc.SetEscapeHTML(false)
-			err = enc.Encode(cm)
+			logEvent(slog.LevelInfo, "saved post", slog.Duration("duration", time.Since(start)))
+		}
+		cm, ok := comments[p.ID]
+		if commentsPerPost {
+			// Only hang on to the comments we need for comments.json
+			postComments, err := getComments(fmt.Sprintf("post=%d", p.ID))
 			if err != nil {
-				fatal("Failed to encode comments: %v", err)
+				postFailed("Failed to fetch comments on %s: %v", p.Link, err)
 			}
+			cm, ok = postComments[p.ID]
+			if ok && saveMeta && len(cm.Approved) > 0 {
+				perPostApproved[p.ID] = cm.Approved
+			}
+		}
+		if ok {
+			saveComments(p, cm)
 		}
+		// Posts with problems are tried again when we resume
+		if !hasFailed() {
+			checkpoint.savePost(p)
+		}
+		postsBar.Add(1)
+	}
+	if len(onlyIDs) > 0 && slices.Contains([]string{"isso", "remark42", "disqus"}, commentFormat) {
+		warn("Not rewriting the %s comments file, as we've only saved some posts", commentFormat)
+	} else {
+		writeComments()
 	}
+	reportRedactions()
+	if saveMeta && commentsPerPost {
+		writeMeta("comments", perPostApproved)
+	}
+	workingOn(LogContext{})
 	status("Saved all posts")
-	if len(errorList.Missing) > 0 {
-		warn("There were %d missing assets", len(errorList.Missing))
+	if saveAuthors {
+		writeAuthors(users, exported)
 	}
-	if len(errorList.Warnings) > 0 {
-		warn("There were %d warnings", len(errorList.Warnings))
+	if saveCategories {
+		writeCategories(categories)
 	}
-	if len(errorList.Missing) > 0 || len(errorList.Warnings) > 0 {
-		writeMeta("errors", errorList)
+	if menuFormat != "" {
+		writeMenus()
 	}
+	return 0
 }
 
 type ResultPost struct {
-	Template   string   `yaml:"template"`
-	Title      string   `yaml:"title"`
-	Date       string   `yaml:"date"`
-	Excerpt    string   `yaml:"excerpt"`
-	Author     string   `yaml:"author"`
-	Categories []string `yaml:"categories"`
-	Tags       []string `yaml:"tags"`
-	Body       string   `yaml:"-"`
+	Template       string     `yaml:"template"`
+	Title          string     `yaml:"title"`
+	Date           string     `yaml:"date"`
+	Lastmod        string     `yaml:"lastmod,omitempty"`
+	Excerpt        string     `yaml:"excerpt"`
+	Author         string     `yaml:"author"`
+	AuthorSlug     string     `yaml:"authorSlug,omitempty"`
+	Authors        []string   `yaml:"authors,omitempty"`
+	Categories     []string   `yaml:"categories"`
+	CategoryPaths  []string   `yaml:"categoryPaths,omitempty"`
+	Tags           []string   `yaml:"tags"`
+	Aliases        []string   `yaml:"aliases,omitempty"`
+	Gallery        []*Gallery `yaml:"gallery,omitempty"`
+	SEO            *SEO       `yaml:"seo,omitempty"`
+	Lang           string     `yaml:"lang,omitempty"`
+	TranslationKey string     `yaml:"translationKey,omitempty"`
+	// Custom taxonomies and fields
+	Extra map[string]interface{} `yaml:",inline"`
+	Body  string                 `yaml:"-"`
 }
 
 // Save metadata as json
 func writeMeta(name string, data interface{}) {
 	filename := filepath.Join(dest, name+".json")
-	of, err := os.Create(filename)
-	if err != nil {
-		fatal("Failed to create %s: %v", filename, err)
-	}
-	encoder := json.NewEncoder(of)
+	var buff bytes.Buffer
+	encoder := json.NewEncoder(&buff)
 	encoder.SetEscapeHTML(false)
 	encoder.SetIndent("", "  ")
-	err = encoder.Encode(data)
+	err := encoder.Encode(data)
 	if err != nil {
 		fatal("Failed to marshal to %s: %v", filename, err)
 	}
+	err = writeFile(filename, buff.Bytes())
+	if err != nil {
+		fatal("Failed to create %s: %v", filename, err)
+	}
 }
 
 // Intuit the (http) path from the link of the post
@@ -285,88 +603,160 @@ func postDirectory(p Post) []string {
 		if err != nil {
 			fatal("failed to parse url of post '%s': %v", p.Link, err)
 		}
-		dir = strings.TrimPrefix(u.Path, prefix)
+		// The site of a network goes first, then anything under it
+		dir = strings.TrimPrefix(strings.TrimPrefix(u.Path, sitePrefix), prefix)
 	}
 	return strings.FieldsFunc(dir, func(c rune) bool { return c == '/' })
 }
 
-func savePost(p Post, frontmatter string) {
-	currentPage = p.Link
+// The url path of a post on the new site
+func postUrlPath(p Post) string {
+	dir := languagePath(p, postDirectory(p))
+	if len(dir) == 0 {
+		return sitePath + "/"
+	}
+	return sitePath + "/" + strings.Join(dir, "/") + "/"
+}
+
+// The posts we've saved, by filename, to catch any overwriting each other
+var savedPosts = map[string]string{}
+
+// savePost writes a post and its assets. Problems that stop us saving it at
+// all are returned, for --keep-going.
+func savePost(p Post, frontmatter string) error {
 	sourceUrl, err := url.Parse(p.Link)
 	if err != nil {
-		fatal("Failed to parse post url '%s': %v", p.Link, err)
+		return fmt.Errorf("failed to parse post url '%s': %w", p.Link, err)
 	}
 	if !sourceUrl.IsAbs() {
-		fatal("Post URL '%s' isn't absolute", p.Link)
+		return fmt.Errorf("post URL '%s' isn't absolute", p.Link)
 	}
 	status("Processing %s", sourceUrl.Path)
-	_, err = time.Parse("2006-01-02T15:04:05", p.DateGmt)
+	date, err := postTime(p.Date, p.DateGmt)
 	if err != nil {
 		warn("Failed to parse date for %s '%s': %v", p.Link, p.DateGmt, err)
+		date = p.DateGmt
+	}
+	lastmod, err := postTime(p.Modified, p.ModifiedGmt)
+	if err != nil && p.ModifiedGmt != "" {
+		warn("Failed to parse modified date for %s '%s': %v", p.Link, p.ModifiedGmt, err)
 	}
-	postPath := postDirectory(p)
-
 	// Where do we write the output for this post?
-	outputDir := filepath.Join(append([]string{dest}, postPath...)...)
+	outputDir, filename := postOutput(p)
 	err = os.MkdirAll(outputDir, 0755)
 	if err != nil {
-		fatal("Failed to create directory %s: %v", outputDir, err)
+		return fmt.Errorf("failed to create directory %s: %w", outputDir, err)
 	}
-	outputFile := filepath.Join(outputDir, postFilename)
-	of, err := os.Create(outputFile)
-	if err != nil {
-		fatal("Failed to create file %s: %v", outputFile, err)
+	outputFile := filepath.Join(outputDir, filename)
+	if other, ok := savedPosts[outputFile]; ok {
+		warn("%s and %s are both saved as %s", other, p.Link, outputFile)
 	}
+	savedPosts[outputFile] = p.Link
 
+	template := "blog-post"
+	if p.Type == "page" {
+		template = "page"
+	}
 	post := ResultPost{
-		Template:   "blog-post",
-		Title:      p.Title.Rendered,
-		Date:       p.DateGmt,
-		Excerpt:    p.Excerpt.Rendered,
-		Author:     p.AuthorName,
-		Categories: p.CategoryNames,
-		Tags:       p.TagNames,
+		Template:       template,
+		Title:          p.Title.Rendered,
+		Date:           date,
+		Lastmod:        lastmod,
+		Excerpt:        p.Excerpt.Rendered,
+		Author:         p.AuthorName,
+		AuthorSlug:     p.AuthorSlug,
+		Authors:        bylineNames(p.Bylines),
+		Extra:          postTerms(p),
+		Categories:     p.CategoryNames,
+		CategoryPaths:  p.CategoryPaths,
+		Tags:           p.TagNames,
+		Aliases:        p.Aliases,
+		Lang:           p.Language,
+		TranslationKey: p.TranslationKey,
 	}
 
 	// Parse the rendered content of the post
 	tree, err := html.Parse(bytes.NewReader([]byte(p.Content.Rendered)))
 	if err != nil {
-		fatal("Couldn't parse html for %s: %v", p.Link, err)
+		return fmt.Errorf("couldn't parse html for %s: %w", p.Link, err)
 	}
 
-	// Write the YAML frontmatter
-	_, _ = of.WriteString("---\n")
-	enc := yaml.NewEncoder(of)
+	for k, v := range postFields(p, outputDir, sourceUrl) {
+		if _, ok := post.Extra[k]; ok {
+			warn("Field '%s' of %s has the same key as a taxonomy", k, p.Link)
+			continue
+		}
+		post.Extra[k] = v
+	}
+
+	if exportSEO {
+		post.SEO = postSEO(p, outputDir, sourceUrl)
+	}
+
+	post.Gallery = fixGalleries(tree, outputDir, sourceUrl, p)
+	fixInternalLinks(tree, outputDir, sourceUrl, postUrlPath(p))
+	fixImages(tree, outputDir, sourceUrl)
+
+	// Write the YAML frontmatter and body, and only then the file, so that
+	// a post we fail to write doesn't leave half a file behind
+	var buff bytes.Buffer
+	_, _ = buff.WriteString("---\n")
+	enc := yaml.NewEncoder(&buff)
 	err = enc.Encode(post)
 	if err != nil {
-		fatal("Failed to encode frontmatter for %s: %v", p.Link, err)
+		return fmt.Errorf("failed to encode frontmatter for %s: %w", p.Link, err)
 	}
 	err = enc.Close()
 	if err != nil {
-		fatal("Failed to close frontmatter for %s: %v", p.Link, err)
+		return fmt.Errorf("failed to close frontmatter for %s: %w", p.Link, err)
 	}
-	_, _ = of.WriteString(frontmatter)
-	_, _ = of.WriteString("---\n")
+	_, _ = buff.WriteString(frontmatter)
+	_, _ = buff.WriteString("---\n")
 
-	fixInternalLinks(tree, outputDir, sourceUrl)
-	fixImages(tree, outputDir, sourceUrl)
-	renderBody(p.Link, tree, of)
+	err = renderBody(p.Link, tree, &buff)
+	if err != nil {
+		return err
+	}
+	err = writeFile(outputFile, buff.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", outputFile, err)
+	}
+	return nil
+}
+
+// rewriteHTML runs a fragment of html through the same link and image
+// rewriting as the body of a post, copying assets into dir
+func rewriteHTML(name string, content string, dir string, sourceUrl *url.URL, pagePath string) string {
+	tree, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		warn("Couldn't parse html for %s: %v", name, err)
+		return content
+	}
+	fixInternalLinks(tree, dir, sourceUrl, pagePath)
+	fixImages(tree, dir, sourceUrl)
+	var buff bytes.Buffer
+	err = renderBody(name, tree, &buff)
+	if err != nil {
+		warn("%v", err)
+		return content
+	}
+	return buff.String()
 }
 
-func renderBody(name string, root *html.Node, w io.Writer) {
+func renderBody(name string, root *html.Node, w io.Writer) error {
 	bodyNode := findBody(root)
 	if bodyNode == nil {
-		fatal("Failed to find body in %s", name)
-		panic("not reached")
+		return fmt.Errorf("failed to find body in %s", name)
 	}
 	child := bodyNode.FirstChild
 	for child != nil {
 		err := html.Render(w, child)
 		if err != nil {
-			fatal("Failed to render body in %s: %v", name, err)
+			return fmt.Errorf("failed to render body in %s: %w", name, err)
 		}
 		child = child.NextSibling
 	}
+	return nil
 }
 
 type Asset struct {
@@ -450,20 +840,42 @@ func copyImage(assetUrl string, sourceUrl *url.URL) *Asset {
 
 var plausibleSuffixRe = regexp.MustCompile(`\.(png|jpg|gif|pdf|jpeg|webp)$`)
 
+// Assets we've already copied, so that we only fetch each once per directory
+var fetchedAssets = map[string]string{}
+
 func fetchAsset(asset *Asset, dir string) string {
 	if !mirror && !strings.HasPrefix(strings.ToLower(asset.Url.Path), wpUploads) {
 		// internal link to a page, so don't mirror it
 		return asset.Url.Path
 	}
+	key := dir + "\x00" + asset.Url.String()
+	if filename, ok := fetchedAssets[key]; ok {
+		return filename
+	}
+	// We only find assets as we go, so the total grows with them
+	assetsBar.AddTotal(1)
+	filename := copyAsset(asset, dir)
+	assetsBar.Add(1)
+	fetchedAssets[key] = filename
+	if filename == asset.Filename {
+		checkpoint.saveAsset(key, filename)
+	}
+	return filename
+}
+
+func copyAsset(asset *Asset, dir string) string {
 	if !plausibleSuffixRe.MatchString(asset.Filename) {
 		warn("Suspicious filename: %s", asset.Filename)
 	}
+	start := time.Now()
 	resp, err := get(asset.Url.String())
 	if err != nil {
 		warn("Failed to get linked file %s: %v", asset.Url, err)
 		return asset.Url.String()
 	} else {
 		if resp.StatusCode != 200 {
+			logEvent(slog.LevelWarn, "missing asset", slog.String("asset_url", asset.Url.String()),
+				slog.Int("status", resp.StatusCode), slog.Duration("duration", time.Since(start)))
 			errorList.Missing = append(errorList.Missing, Missing{
 				Page:   dir,
 				URL:    asset.Url.String(),
@@ -472,41 +884,51 @@ func fetchAsset(asset *Asset, dir string) string {
 			//warn("Non-200 response fetching file: %s (%s)", asset.Url, resp.Status)
 			return asset.Url.String()
 		} else {
-			of, err := os.Create(filepath.Join(dir, asset.Filename))
+			filename := filepath.Join(dir, asset.Filename)
+			n, err := copyToFile(filename, resp.Body)
 			if err != nil {
-				fatal("Failed to write %s to %s: %v", asset.Filename, dir, err)
-			}
-			_, err = io.Copy(of, resp.Body)
-			if err != nil {
-				fatal("Failed to copy %s to %s/%s: %v", asset.Url, dir, asset.Filename, err)
+				postFailed("Failed to copy %s to %s: %v", asset.Url, filename, err)
+				return asset.Url.String()
 			}
+			logEvent(slog.LevelInfo, "saved asset", slog.String("asset_url", asset.Url.String()),
+				slog.Int("status", resp.StatusCode), slog.Int64("bytes", n), slog.Duration("duration", time.Since(start)))
+			assetsBar.AddBytes(n)
 			return asset.Filename
 		}
 	}
 }
 
-func fixInternalLinks(node *html.Node, dir string, sourceUrl *url.URL) {
+func fixInternalLinks(node *html.Node, dir string, sourceUrl *url.URL, pagePath string) {
 	if node.Type == html.ElementNode && node.Data == "a" {
 		for i, attr := range node.Attr {
 			if attr.Key == "href" {
-				asset := localAsset(attr.Val, sourceUrl)
-				if asset != nil {
-					node.Attr[i] = html.Attribute{
-						Namespace: "",
-						Key:       "href",
-						Val:       fetchAsset(asset, dir),
-					}
+				node.Attr[i] = html.Attribute{
+					Namespace: "",
+					Key:       "href",
+					Val:       internalLink(attr.Val, dir, sourceUrl, pagePath),
 				}
 			}
 		}
 	}
 	child := node.FirstChild
 	for child != nil {
-		fixInternalLinks(child, dir, sourceUrl)
+		fixInternalLinks(child, dir, sourceUrl, pagePath)
 		child = child.NextSibling
 	}
 }
 
+// internalLink is where a link on the page at pagePath should go now: to our
+// copy of a file, to the new home of a post, or wherever it went before
+func internalLink(href string, dir string, sourceUrl *url.URL, pagePath string) string {
+	if asset := localAsset(href, sourceUrl); asset != nil {
+		return fetchAsset(asset, dir)
+	}
+	if newUrl := rewriteLink(href, sourceUrl, pagePath); newUrl != "" {
+		return newUrl
+	}
+	return href
+}
+
 func fixImages(node *html.Node, dir string, sourceUrl *url.URL) {
 	if node.Type == html.ElementNode && node.Data == "img" {
 		for i, attr := range node.Attr {
@@ -573,11 +995,12 @@ type Tag struct {
 	Slug        string
 	Description string
 	Taxonomy    string
+	Link        string
 }
 
 func getTags() map[int]*Tag {
 	result := []Tag{}
-	fetch("tags", &result, "tags?context=view&_fields=id,name,slug,description,taxonomy")
+	fetch("tags", &result, "tags?context=view&_fields=id,name,slug,description,taxonomy,link")
 	rm := map[int]*Tag{}
 	for idx, r := range result {
 		_, ok := rm[r.ID]
@@ -590,14 +1013,18 @@ func getTags() map[int]*Tag {
 }
 
 type Category struct {
-	ID   int
-	Name string
-	Slug string
+	ID          int
+	Name        string
+	Slug        string
+	Parent      int
+	Description string
+	Count       int
+	Link        string
 }
 
 func getCategories() map[int]*Category {
 	result := []Category{}
-	fetch("categories", &result, "categories?context=view&_fields=id,name,slug")
+	fetch("categories", &result, "categories?context=view&_fields=id,name,slug,parent,description,count,link")
 	rm := map[int]*Category{}
 	for idx, r := range result {
 		_, ok := rm[r.ID]
@@ -610,22 +1037,30 @@ func getCategories() map[int]*Category {
 }
 
 type User struct {
-	ID   int
-	Name string
-	Slug string
+	ID          int
+	Name        string
+	Slug        string
+	Link        string
+	Description string
+	URL         string
+	AvatarURLs  map[string]string `json:"avatar_urls,omitempty" mapstructure:"avatar_urls"`
+	FirstName   string            `json:"first_name,omitempty" mapstructure:"first_name"`
+	LastName    string            `json:"last_name,omitempty" mapstructure:"last_name"`
 }
 
 func getUsers() map[int]*User {
 	result := []User{}
-	fetch("users", &result, "users?context=view&_fields=id,name,slug")
+	fetch("users", &result, "users?context=view&_fields=id,name,slug,link,description,url,avatar_urls")
 	rm := map[int]*User{}
 	for idx, r := range result {
 		_, ok := rm[r.ID]
 		if ok {
 			fatal("duplicate user: %d", r.ID)
 		}
+		result[idx].AvatarURLs = localAvatars(r.AvatarURLs)
 		rm[r.ID] = &result[idx]
 	}
+	getUserNames(rm)
 	return rm
 }
 
@@ -633,6 +1068,7 @@ type Comment struct {
 	ID               int
 	Author           int               `json:"author,omitempty" mapstructure:"author,omitempty"`
 	AuthorEmail      string            `json:"author_email,omitempty" mapstructure:"author_email,omitempty"`
+	AuthorEmailHash  string            `json:"author_email_hash,omitempty" mapstructure:"-"`
 	AuthorIP         string            `json:"author_ip,omitempty" mapstructure:"author_ip,omitempty"`
 	AuthorName       string            `json:"author_name,omitempty" mapstructure:"author_name,omitempty"`
 	AuthorURL        string            `json:"author_url,omitempty" mapstructure:"author_url,omitempty"`
@@ -643,47 +1079,198 @@ type Comment struct {
 	Link             string            `json:"link,omitempty" mapstructure:"link,omitempty"`
 	Parent           int               `json:"parent,omitempty" mapstructure:"parent,omitempty"`
 	Post             int               `json:"post,omitempty" mapstructure:"post,omitempty"`
+	Status           string            `json:"status,omitempty" mapstructure:"status,omitempty"`
 	Type             string            `json:"type,omitempty" mapstructure:"type,omitempty"`
 	AuthorAvatarURLs map[string]string `json:"author_avatar_urls,omitempty" mapstructure:"author_avatar_urls,omitempty"`
 	Meta             []any             `json:"meta,omitempty" mapstructure:"meta,omitempty"`
 }
 
-func getComments() map[int][]Comment {
+// PostComments are the comments on one post, split up by type and status
+type PostComments struct {
+	Approved []Comment
+	Pings    []Comment
+	Status   map[string][]Comment
+}
+
+// Comment statuses we can only see when authenticated
+var moderationStatuses = []string{"hold", "spam", "trash"}
+
+// Fetch comments, approved ones and, if we're authenticated, those awaiting
+// moderation, spam and trash
+func getComments(query string) (map[int]*PostComments, error) {
+	const fields = "comments?context=view&_fields=id,author,author_email,author_ip,author_name,author_url,author_user_agent,content,date,date_gmt,link,parent,post,status,type,author_avatar_urls,meta"
+	// Comments for a single post are fetched for every post, so keep quiet
+	quiet := query != ""
+	if query != "" {
+		query = "&" + query
+	}
+	ret := map[int]*PostComments{}
+	forPost := func(id int) *PostComments {
+		pc, ok := ret[id]
+		if !ok {
+			pc = &PostComments{Status: map[string][]Comment{}}
+			ret[id] = pc
+		}
+		return pc
+	}
+	add := func(status string, result []Comment) {
+		for _, r := range result {
+			r.AuthorAvatarURLs = localAvatars(r.AuthorAvatarURLs)
+			pc := forPost(r.Post)
+			switch {
+			case r.Type == "pingback" || r.Type == "trackback":
+				if pingbacks == "separate" {
+					pc.Pings = append(pc.Pings, r)
+				} else if pingbacks == "keep" {
+					pc.Approved = append(pc.Approved, r)
+				}
+			case status == "approve":
+				pc.Approved = append(pc.Approved, r)
+			default:
+				pc.Status[status] = append(pc.Status[status], r)
+			}
+		}
+	}
+
+	fetchComments := func(name string, result *[]Comment, parameters string) error {
+		return fetchListing(Listing{Name: name, Quiet: quiet}, result, "wp/v2/"+parameters)
+	}
 	result := []Comment{}
-	fetch("comments", &result, "comments?context=view&_fields=id,author,author_email,author_ip,author_name,author_url,author_user_agent,content,date,date_gmt,link,parent,post,type,author_avatar_urls,meta")
+	err := fetchComments("comments", &result, fields+query)
+	if err != nil {
+		return nil, err
+	}
+	redactComments(result)
+	add("approve", result)
+	if pingbacks != "drop" {
+		// The API only returns normal comments unless we ask
+		result = []Comment{}
+		err = fetchComments("pingbacks", &result, fields+"&type=pings"+query)
+		if err != nil {
+			return nil, err
+		}
+		redactComments(result)
+		add("approve", result)
+	}
+	if auth != "" {
+		for _, status := range moderationStatuses {
+			result = []Comment{}
+			err := fetchComments(status+" comments", &result, fields+"&status="+status+query)
+			if err != nil {
+				warn("Failed to fetch %s comments: %v", status, err)
+				continue
+			}
+			redactComments(result)
+			add(status, result)
+		}
+	}
+	return ret, nil
+}
+
+// The approved comments on each post
+func approvedComments(comments map[int]*PostComments) map[int][]Comment {
 	ret := map[int][]Comment{}
-	for _, r := range result {
-		ret[r.Post] = append(ret[r.Post], r)
+	for id, pc := range comments {
+		if len(pc.Approved) > 0 {
+			ret[id] = pc.Approved
+		}
 	}
 	return ret
 }
 
+type Media struct {
+	ID        int
+	SourceURL string `json:"source_url" mapstructure:"source_url"`
+	Link      string
+	AltText   string `json:"alt_text" mapstructure:"alt_text"`
+	Caption   Rendered
+	Post      int
+}
+
+// Fetch media items matching a query
+func fetchMedia(query string) ([]Media, error) {
+	result := []Media{}
+	err := fetchRoute("media", &result, "wp/v2/media?context=view&_fields=id,source_url,link,alt_text,caption,post&"+query)
+	return result, err
+}
+
 type Rendered struct {
 	Rendered string
 }
 
 type Post struct {
-	ID         int
-	DateGmt    string `json:"date_gmt" mapstructure:"date_gmt"`
-	Slug       string
-	Status     string
-	Title      Rendered
-	Content    Rendered
-	Excerpt    Rendered
-	Author     int
-	Categories []int
-	Tags       []int
-	Link       string
-
-	AuthorName    string
-	CategoryNames []string
-	TagNames      []string
-}
-
-// Fetch all the WordPress posts
-func getPosts() []Post {
+	ID               int
+	Date             string
+	DateGmt          string `json:"date_gmt" mapstructure:"date_gmt"`
+	Modified         string
+	ModifiedGmt      string `json:"modified_gmt" mapstructure:"modified_gmt"`
+	Slug             string
+	Status           string
+	Type             string
+	JetpackShortlink string                 `json:"jetpack_shortlink" mapstructure:"jetpack_shortlink"`
+	Coauthors        []interface{}          `mapstructure:"coauthors"`
+	PPAuthors        []interface{}          `mapstructure:"authors"`
+	PPMAAuthor       []int                  `mapstructure:"ppma_author"`
+	Extra            map[string]interface{} `mapstructure:",remain"`
+	Title            Rendered
+	Content          Rendered
+	Excerpt          Rendered
+	Author           int
+	Categories       []int
+	Tags             []int
+	Link             string
+
+	AuthorName     string
+	AuthorSlug     string
+	Bylines        []Byline
+	CategoryNames  []string
+	CategoryPaths  []string
+	TagNames       []string
+	Aliases        []string
+	Language       string
+	TranslationKey string
+}
+
+// Fetch all the WordPress posts, or pages
+func getPosts(kind string) []Post {
 	result := []Post{}
-	fetch("posts", &result, "posts?context=view&_fields=id,date_gmt,slug,status,title,content,excerpt,author,categories,tags,link")
+	fields := "id,date,date_gmt,modified,modified_gmt,slug,status,type,title,content,excerpt,author,categories,tags,link,jetpack_shortlink,coauthors,authors,ppma_author"
+	for _, t := range customTaxonomies {
+		fields += "," + t.RestBase
+	}
+	if fieldMapping != nil {
+		fields += ",acf,meta"
+	}
+	if exportSEO {
+		fields += ",yoast_head_json,aioseo_head"
+	}
+	fields += ",lang,translations,wpml_current_locale,wpml_translations"
+	if len(languages) == 0 {
+		fetch(kind, &result, kind+"?context=view&_fields="+fields)
+	} else {
+		// Multilingual plugins that only list one language at a time
+		seen := map[int]bool{}
+		for _, lang := range languages {
+			langResult := []Post{}
+			l := listing(kind)
+			l.Name = kind + " in " + lang
+			err := fetchListing(l, &langResult, "wp/v2/"+kind+"?context=view&lang="+url.QueryEscape(lang)+"&_fields="+fields)
+			if err != nil {
+				fatal("%v", err)
+			}
+			for _, p := range langResult {
+				if seen[p.ID] {
+					continue
+				}
+				seen[p.ID] = true
+				p.Language = lang
+				result = append(result, p)
+			}
+		}
+		if l := listing(kind); l.Limit > 0 && len(result) > l.Limit {
+			result = result[:l.Limit]
+		}
+	}
 
 	rm := map[int]struct{}{}
 	for _, r := range result {
@@ -704,26 +1291,29 @@ type Response struct {
 	Body        *bytes.Reader `json:"-"`
 	ContentType string
 	Error       string
+	// From the X-WP-Total and X-WP-TotalPages headers of listings
+	Total      int
+	TotalPages int
 }
 
 // get does an http.Get with a local cache
 func get(u string) (Response, error) {
 	var key string
 	if cacheDir != "" {
+		// Authenticated requests can see more, so cache them separately
 		key = fmt.Sprintf("%16x", md5.Sum([]byte(u)))
+		if auth != "" && isApiRequest(u) {
+			key = fmt.Sprintf("%16x", md5.Sum([]byte(auth+"\x00"+u)))
+		}
 		// fmt.Printf("%s -> %s\n", key, u)
-		f, err := os.Open(filepath.Join(cacheDir, key))
+		resp, err := loadResponse(filepath.Join(cacheDir, key))
 		if err == nil {
-			var resp Response
-			dec := json.NewDecoder(f)
-			err = dec.Decode(&resp)
-			if err == nil {
-				if resp.Error != "" {
-					return Response{}, errors.New(resp.Error)
-				}
-				resp.Body = bytes.NewReader(resp.BodyContent)
-				return resp, nil
+			if resp.Error != "" {
+				return Response{}, errors.New(resp.Error)
 			}
+			return resp, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
 			warn("error decoding cached response for %s: %v", u, err)
 		}
 	}
@@ -734,8 +1324,16 @@ func get(u string) (Response, error) {
 	if userAgent != "" {
 		req.Header.Set("User-Agent", userAgent)
 	}
+	if auth != "" && isApiRequest(u) {
+		// Only send credentials to the API, never to asset hosts
+		user, password, _ := strings.Cut(auth, ":")
+		req.SetBasicAuth(user, password)
+	}
+	throttle()
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
+		logFetch(u, 0, time.Since(start), err)
 		cacheResponse(key, Response{
 			Request: u,
 			Error:   err.Error(),
@@ -748,6 +1346,7 @@ func get(u string) (Response, error) {
 		return Response{}, err
 	}
 	_ = resp.Body.Close()
+	logFetch(u, resp.StatusCode, time.Since(start), nil)
 	r := Response{
 		Request:     u,
 		StatusCode:  resp.StatusCode,
@@ -756,26 +1355,70 @@ func get(u string) (Response, error) {
 		ContentType: resp.Header.Get("Content-Type"),
 		Body:        bytes.NewReader(body),
 	}
+	r.Total, _ = strconv.Atoi(resp.Header.Get("X-WP-Total"))
+	r.TotalPages, _ = strconv.Atoi(resp.Header.Get("X-WP-TotalPages"))
 	cacheResponse(key, r)
 	return r, nil
 }
 
+var rateLimiter struct {
+	sync.Mutex
+	next time.Time
+}
+
+// throttle waits until we're allowed to make another request, shared
+// between everything we fetch
+func throttle() {
+	if rateLimit <= 0 {
+		return
+	}
+	rateLimiter.Lock()
+	now := time.Now()
+	if rateLimiter.next.Before(now) {
+		rateLimiter.next = now
+	}
+	wait := rateLimiter.next.Sub(now)
+	rateLimiter.next = rateLimiter.next.Add(time.Duration(float64(time.Second) / rateLimit))
+	rateLimiter.Unlock()
+	time.Sleep(wait)
+}
+
 func cacheResponse(key string, r Response) {
 	if cacheDir != "" {
-		f, err := os.Create(filepath.Join(cacheDir, key))
-		if err == nil {
-			enc := json.NewEncoder(f)
-			enc.SetEscapeHTML(false)
-			err = enc.Encode(r)
-			if err != nil {
-				fatal("failed to cache response for %s: %v", r.Request, err)
-			}
-		} else {
+		err := saveResponse(filepath.Join(cacheDir, key), r)
+		if err != nil {
 			fatal("failed to cache response for %s: %v", r.Request, err)
 		}
 	}
 }
 
+// loadResponse reads a response we saved, for the cache or a checkpoint
+func loadResponse(filename string) (Response, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return Response{}, err
+	}
+	defer f.Close()
+	var resp Response
+	err = json.NewDecoder(f).Decode(&resp)
+	if err != nil {
+		return Response{}, err
+	}
+	resp.Body = bytes.NewReader(resp.BodyContent)
+	return resp, nil
+}
+
+func saveResponse(filename string, r Response) error {
+	var buff bytes.Buffer
+	enc := json.NewEncoder(&buff)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(r)
+	if err != nil {
+		return err
+	}
+	return writeFile(filename, buff.Bytes())
+}
+
 func head(u string) (Response, error) {
 	// If we're not caching results we could just a HEAD here rather than GET
 	return get(u)
@@ -783,117 +1426,359 @@ func head(u string) (Response, error) {
 
 // Fetch a result set from WordPress, unmarshall it to our result
 func fetch(name string, result interface{}, parameters string) {
-	u, err := url.Parse(apiUrl + "wp/v2/" + parameters)
+	err := fetchRoute(name, result, "wp/v2/"+parameters)
+	if err != nil {
+		fatal("%v", err)
+	}
+}
+
+// fetchRoute fetches every page of results from any API route
+func fetchRoute(name string, result interface{}, route string) error {
+	return fetchListing(listing(name), result, route)
+}
+
+// Listing is what we're fetching from a paginated route
+type Listing struct {
+	Name string
+	// The most items we want, or zero for all of them
+	Limit int
+	// Fetched for each post, so too many to report
+	Quiet bool
+}
+
+// listing is a listing of everything, or of a sample with --sample
+func listing(name string) Listing {
+	l := Listing{Name: name}
+	if sample > 0 && name == "posts" {
+		l.Limit = sample
+	}
+	return l
+}
+
+func fetchListing(l Listing, result interface{}, route string) error {
+	u, err := routeUrl(route)
+	if err != nil {
+		return fmt.Errorf("failed to build api url for %s: %v", l.Name, err)
+	}
+	raw, err := getAll(u, l)
 	if err != nil {
-		fatal("failed to build api url for %s: %v", name, err)
+		return err
 	}
-	raw := getAll(u, name)
-	err = mapstructure.Decode(raw, result)
+	err = decodeWeak(raw, result)
 	if err != nil {
-		fatal("failed to parse result for %s: %v", name, err)
+		return fmt.Errorf("failed to parse result for %s: %v", l.Name, err)
 	}
+	return nil
+}
+
+// getJSON fetches a single, unpaginated, API route
+func getJSON(route string, result interface{}) error {
+	u, err := routeUrl(route)
+	if err != nil {
+		return fmt.Errorf("failed to build api url for %s: %v", route, err)
+	}
+	res, err := get(u.String())
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %v", u, err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return apiError(u, res)
+	}
+	var raw interface{}
+	err = json.NewDecoder(res.Body).Decode(&raw)
+	if err != nil {
+		return fmt.Errorf("failed to parse response from %s: %v", u, err)
+	}
+	err = decodeWeak(raw, result)
+	if err != nil {
+		return fmt.Errorf("failed to parse result for %s: %v", route, err)
+	}
+	return nil
+}
+
+// routeUrl builds the url for an API route, either under the API url or,
+// for sites without pretty permalinks, in the rest_route parameter
+func routeUrl(route string) (*url.URL, error) {
+	base, err := url.Parse(apiUrl)
+	if err != nil {
+		return nil, err
+	}
+	if !base.Query().Has("rest_route") {
+		return url.Parse(apiUrl + route)
+	}
+	routePath, routeQuery, _ := strings.Cut(route, "?")
+	q, err := url.ParseQuery(routeQuery)
+	if err != nil {
+		return nil, err
+	}
+	bq := base.Query()
+	for k, v := range q {
+		bq[k] = v
+	}
+	bq.Set("rest_route", strings.TrimSuffix(bq.Get("rest_route"), "/")+"/"+routePath)
+	base.RawQuery = bq.Encode()
+	return base, nil
+}
+
+// isApiRequest is whether a url is for the API, rather than an asset
+func isApiRequest(u string) bool {
+	if strings.HasPrefix(u, apiUrl) {
+		return true
+	}
+	base, err := url.Parse(apiUrl)
+	if err != nil || !base.Query().Has("rest_route") {
+		return false
+	}
+	ru, err := url.Parse(u)
+	return err == nil && ru.Scheme == base.Scheme && ru.Host == base.Host && ru.Path == base.Path && ru.Query().Has("rest_route")
+}
+
+// decodeWeak is mapstructure.Decode, but tolerant of plugins that return
+// numbers as strings
+func decodeWeak(input interface{}, result interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		WeaklyTypedInput: true,
+		Result:           result,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(input)
+}
+
+// apiError describes a failed API request, including the WordPress error
+// code if there is one
+func apiError(u *url.URL, res Response) error {
+	var wpErr struct {
+		Code    string
+		Message string
+	}
+	if json.Unmarshal(res.BodyContent, &wpErr) == nil && wpErr.Code != "" {
+		return fmt.Errorf("failed to fetch %s: %s (%s: %s)", u, res.Status, wpErr.Code, wpErr.Message)
+	}
+	return fmt.Errorf("failed to fetch %s: %s", u, res.Status)
 }
 
 // Handle pagination for an arbitrary WordPress REST query
-func getAll(u *url.URL, name string) []interface{} {
+func getAll(u *url.URL, l Listing) ([]interface{}, error) {
+	name := l.Name
 	limit := 1000000000
-	if sample > 0 && name == "posts" {
-		limit = sample
+	if l.Limit > 0 {
+		limit = l.Limit
 	}
-	ret := []interface{}{}
-	page := 1
 	pageSize := 100
 	if limit < pageSize {
 		pageSize = limit
 	}
-	q := u.Query()
-	q.Set("per_page", strconv.Itoa(pageSize))
-	for {
+	query := u.Query()
+	query.Set("per_page", strconv.Itoa(pageSize))
+	pageUrl := func(page int) string {
+		q := maps.Clone(query)
 		q.Set("page", strconv.Itoa(page))
-		u.RawQuery = q.Encode()
+		pu := *u
+		pu.RawQuery = q.Encode()
+		return pu.String()
+	}
 
-		status("fetching %s %d ...", name, (page-1)*pageSize)
-		res, err := get(u.String())
+	start := time.Now()
+	if !l.Quiet {
+		status("fetching %s ...", name)
+	}
+	pagesBar.AddTotal(1)
+	ret, res, err := getPage(pageUrl(1))
+	if err != nil {
+		return nil, err
+	}
+	pagesBar.Add(1)
+	if len(ret) >= pageSize && len(ret) < limit {
+		if res.TotalPages > 0 {
+			ret, err = getPages(l, res, ret, pageUrl, limit, pageSize, start)
+		} else {
+			ret, err = getPagesInTurn(l, ret, pageUrl, limit, pageSize)
+		}
 		if err != nil {
-			fatal("failed to fetch %s: %v", u, err)
+			return nil, err
 		}
+	}
+	if len(ret) > limit {
+		ret = ret[:limit]
+	}
+	if !l.Quiet {
+		endStatus("fetched %d %s", len(ret), name)
+	}
+	return ret, nil
+}
 
-		decoder := json.NewDecoder(res.Body)
-		thisPage := []interface{}{}
-		err = decoder.Decode(&thisPage)
-		if err != nil {
-			fatal("failed to parse response from %s: %v", u, err)
+// getPages fetches the rest of the pages of a listing in parallel, when
+// WordPress has told us how many there are
+func getPages(l Listing, first Response, ret []interface{}, pageUrl func(int) string, limit int, pageSize int, start time.Time) ([]interface{}, error) {
+	pages := first.TotalPages
+	if maxPages := (limit + pageSize - 1) / pageSize; maxPages < pages {
+		pages = maxPages
+	}
+	total := first.Total
+	if total == 0 || total > limit {
+		total = min(limit, pages*pageSize)
+	}
+
+	pagesBar.AddTotal(pages - 1)
+
+	type result struct {
+		page  int
+		items []interface{}
+		err   error
+	}
+	urls := make(chan int)
+	results := make(chan result)
+	for w := 0; w < min(concurrency, pages-1); w++ {
+		go func() {
+			for page := range urls {
+				items, _, err := getPage(pageUrl(page))
+				results <- result{page: page, items: items, err: err}
+			}
+		}()
+	}
+	go func() {
+		for page := 2; page <= pages; page++ {
+			urls <- page
 		}
-		ret = append(ret, thisPage...)
-		if len(thisPage) < pageSize || len(ret) >= limit {
-			endStatus("fetched %d %s", len(ret), name)
-			return ret
+		close(urls)
+	}()
+
+	byPage := make([][]interface{}, pages+1)
+	byPage[1] = ret
+	fetched := len(ret)
+	var firstErr error
+	for done := 2; done <= pages; done++ {
+		r := <-results
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			continue
+		}
+		byPage[r.page] = r.items
+		pagesBar.Add(1)
+		fetched += len(r.items)
+		if !l.Quiet {
+			pageProgress(l.Name, done, pages, fetched, total, start)
 		}
-		page++
 	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	ret = []interface{}{}
+	for _, items := range byPage {
+		ret = append(ret, items...)
+	}
+	return ret, nil
 }
 
-var lfNeeded = false
-var statusLen = 0
+// getPagesInTurn fetches the rest of the pages of a listing one after
+// another, for servers that don't tell us how many there are
+func getPagesInTurn(l Listing, ret []interface{}, pageUrl func(int) string, limit int, pageSize int) ([]interface{}, error) {
+	for page := 2; ; page++ {
+		if !l.Quiet {
+			status("fetching %s %d ...", l.Name, (page-1)*pageSize)
+		}
+		pagesBar.AddTotal(1)
+		items, res, err := getPage(pageUrl(page))
+		if err != nil {
+			// We've gone past the last page, when there are an exact multiple of pageSize
+			if res.StatusCode == http.StatusBadRequest && bytes.Contains(res.BodyContent, []byte("rest_post_invalid_page_number")) {
+				pagesBar.AddTotal(-1)
+				return ret, nil
+			}
+			return nil, err
+		}
+		pagesBar.Add(1)
+		ret = append(ret, items...)
+		if len(items) < pageSize || len(ret) >= limit {
+			return ret, nil
+		}
+	}
+}
 
-func status(format string, a ...interface{}) {
-	if !quiet {
-		lfNeeded = true
-		msg := fmt.Sprintf(format, a...)
-		_, _ = io.WriteString(os.Stderr, "\r"+msg)
-		if len(msg) < statusLen {
-			_, _ = io.WriteString(os.Stderr, strings.Repeat(" ", statusLen-len(msg))+"\r")
+// getPage fetches one page of a listing
+func getPage(pu string) ([]interface{}, Response, error) {
+	u, _ := url.Parse(pu)
+	res, ok := checkpoint.page(pu)
+	if !ok {
+		var err error
+		res, err = get(pu)
+		if err != nil {
+			return nil, res, fmt.Errorf("failed to fetch %s: %v", pu, err)
 		}
-		statusLen = len(msg)
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			return nil, res, apiError(u, res)
+		}
+		checkpoint.savePage(pu, res)
+	}
+	items := []interface{}{}
+	err := json.NewDecoder(res.Body).Decode(&items)
+	if err != nil {
+		return nil, res, fmt.Errorf("failed to parse response from %s: %v", pu, err)
 	}
+	return items, res, nil
 }
 
-func endStatus(format string, a ...interface{}) {
-	status("")
-	lfNeeded = false
-	info(format, a...)
+// pageProgress shows how far through a listing we are, like
+// "page 12/37, 1,184/3,650 posts, 0:42 left"
+func pageProgress(name string, page int, pages int, fetched int, total int, start time.Time) {
+	eta := ""
+	if page > 1 {
+		perPage := time.Since(start) / time.Duration(page)
+		eta = fmt.Sprintf(", %s left", (perPage * time.Duration(pages-page)).Round(time.Second))
+	}
+	status("fetching %s: page %d/%d, %s/%s %s%s", name, page, pages, thousands(fetched), thousands(total), name, eta)
 }
 
-func skipStatus() {
-	if lfNeeded {
-		lfNeeded = false
-		_, _ = io.WriteString(os.Stdout, "\n")
+// thousands formats a number with commas, like 3,650
+func thousands(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
 	}
+	return s
 }
 
 func info(format string, a ...interface{}) {
-	msg := fmt.Sprintf(format, a...) + "\n"
-	if logWriter != nil {
-		_, _ = io.WriteString(logWriter, msg)
-	}
+	msg := fmt.Sprintf(format, a...)
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	logEventLocked(slog.LevelInfo, msg)
+	msg += "\n"
 	if !quiet {
-		skipStatus()
+		progress.clearLocked()
 		_, _ = io.WriteString(os.Stdout, msg)
+		progress.restoreLocked()
 	}
 }
 
 func warn(format string, a ...interface{}) {
-	msg := fmt.Sprintf(format, a...) + "\n"
+	msg := fmt.Sprintf(format, a...)
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	logEventLocked(slog.LevelWarn, msg)
+	msg += "\n"
 	errorList.Warnings = append(errorList.Warnings, Warning{
-		Page:    currentPage,
+		Page:    logContext.PostLink,
 		Message: msg,
 	})
-	if logWriter != nil {
-		_, _ = io.WriteString(logWriter, "WARN: "+msg)
-	}
 	if !silent {
-		skipStatus()
+		progress.clearLocked()
 		color.Yellow.Print("WARN: ")
 		_, _ = io.WriteString(os.Stdout, msg)
+		progress.restoreLocked()
 	}
 }
 
 func fatal(format string, a ...interface{}) {
-	msg := fmt.Sprintf(format, a...) + "\n"
-	if logWriter != nil {
-		_, _ = io.WriteString(logWriter, "FATAL: "+msg)
-	}
-	skipStatus()
+	msg := fmt.Sprintf(format, a...)
+	outputMu.Lock()
+	logEventLocked(slog.LevelError, msg)
+	msg += "\n"
+	progress.clearLocked()
 	color.Red.Print("ERROR: ")
 	_, _ = io.WriteString(os.Stdout, msg)
 	os.Exit(1)
@@ -918,29 +1803,90 @@ func parseURL(rawurl string) (*url.URL, error) {
 	return nil, fmt.Errorf("invalid URL: '%s'", rawurl)
 }
 
+var apiLinkRe = regexp.MustCompile(`\s*<([^>]+)>\s*;\s*rel="https://api\.w\.org/"`)
+
 // findApi does discovers the API fo a wordpress site, as documented at
 // https://developer.wordpress.org/rest-api/using-the-rest-api/discovery/
+// falling back to guessing where it is when the site doesn't tell us
 func findApi(siteUrl *url.URL) string {
+	// The client follows redirects, such as to www. or https
+	throttle()
 	head, err := client.Head(siteUrl.String())
+	if err == nil {
+		_ = head.Body.Close()
+		if head.StatusCode == http.StatusOK {
+			if api := apiFromHeader(head.Header); api != "" {
+				return api
+			}
+		}
+		siteUrl = head.Request.URL
+	}
+
+	// Some CDNs and firewalls block HEAD, or drop the header
+	throttle()
+	resp, err := client.Get(siteUrl.String())
 	if err != nil {
 		fatal("Couldn't fetch %s while looking for site API: %v", siteUrl, err)
 	}
-	if head.StatusCode != http.StatusOK {
-		fatal("Got %s response while fetching %s", head.Status, siteUrl)
+	siteUrl = resp.Request.URL
+	if resp.StatusCode == http.StatusOK {
+		if api := apiFromHeader(resp.Header); api != "" {
+			_ = resp.Body.Close()
+			return api
+		}
+		tree, err := html.Parse(resp.Body)
+		if err == nil {
+			for _, link := range findElements(tree, "link") {
+				if getAttr(link, "rel") == "https://api.w.org/" && getAttr(link, "href") != "" {
+					_ = resp.Body.Close()
+					return getAttr(link, "href")
+				}
+			}
+		}
+	} else {
+		warn("Got %s response while fetching %s", resp.Status, siteUrl)
 	}
-	links, ok := head.Header["Link"]
-	if !ok {
-		fatal("No Link: headers in response from %s", siteUrl)
+	_ = resp.Body.Close()
+
+	// Try where the API usually is, with and without pretty permalinks
+	home := siteUrl.ResolveReference(&url.URL{Path: "./"})
+	home.RawQuery = ""
+	for _, guess := range []string{"wp-json/", "?rest_route=/"} {
+		api := home.String() + guess
+		if isApiIndex(api) {
+			return api
+		}
 	}
+	fatal("Unable to discover API for %s - maybe use the --api flag?", siteUrl)
+	panic("I'm unreachable")
+}
 
+func apiFromHeader(header http.Header) string {
 	// I'm a perl developer at heart
-	linkPattern := regexp.MustCompile(`\s*<([^>]+)>\s*;\s*rel="https://api\.w\.org/"`)
-	for _, link := range links {
-		matches := linkPattern.FindStringSubmatch(link)
+	for _, link := range header.Values("Link") {
+		matches := apiLinkRe.FindStringSubmatch(link)
 		if matches != nil {
 			return matches[1]
 		}
 	}
-	fatal("Unable to discover API for %s - maybe use the --api flag?", siteUrl)
-	panic("I'm unreachable")
+	return ""
+}
+
+// isApiIndex checks whether a url looks like the root of a WordPress API
+func isApiIndex(u string) bool {
+	throttle()
+	resp, err := client.Get(u)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	var index struct {
+		Namespaces []string
+		Routes     map[string]interface{}
+	}
+	err = json.NewDecoder(resp.Body).Decode(&index)
+	return err == nil && (len(index.Namespaces) > 0 || len(index.Routes) > 0)
 }
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// setFor sets one of our globals for the rest of a test
+func setFor[T any](t *testing.T, v *T, value T) {
+	t.Helper()
+	old := *v
+	*v = value
+	t.Cleanup(func() { *v = old })
+}
+
+// testApi serves the API from handler for the rest of a test
+func testApi(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	setFor(t, &apiUrl, server.URL+"/wp-json/")
+	setFor(t, &client, server.Client())
+	setFor(t, &cacheDir, "")
+	setFor(t, &checkpoint, nil)
+	setFor(t, &rateLimit, 0)
+}
+
+// commentsApi serves a few comments on posts 5 and 6, failing for post 7
+func commentsApi(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("post") == "7" {
+		http.Error(w, `{"code":"oops","message":"broken"}`, http.StatusInternalServerError)
+		return
+	}
+	comments := []map[string]any{
+		{"id": 1, "post": 5, "type": "comment"},
+		{"id": 2, "post": 6, "type": "comment"},
+	}
+	switch {
+	case q.Get("type") == "pings":
+		comments = []map[string]any{{"id": 3, "post": 5, "type": "pingback"}}
+	case q.Get("status") == "hold":
+		comments = []map[string]any{{"id": 4, "post": 5, "type": "comment"}}
+	case q.Has("status"):
+		comments = nil
+	}
+	if post := q.Get("post"); post != "" {
+		comments = slices.DeleteFunc(comments, func(c map[string]any) bool {
+			return fmt.Sprint(c["post"]) != post
+		})
+	}
+	_ = json.NewEncoder(w).Encode(comments)
+}
+
+func TestGetComments(t *testing.T) {
+	testApi(t, commentsApi)
+	setFor(t, &commentPrivacy, "none")
+	ids := func(comments []Comment) []int {
+		ret := []int{}
+		for _, c := range comments {
+			ret = append(ret, c.ID)
+		}
+		return ret
+	}
+	tests := []struct {
+		query     string
+		pingbacks string
+		auth      string
+		approved  map[int][]int
+		pings     map[int][]int
+		held      map[int][]int
+	}{
+		{"", "drop", "", map[int][]int{5: {1}, 6: {2}}, nil, nil},
+		{"", "keep", "", map[int][]int{5: {1, 3}, 6: {2}}, nil, nil},
+		{"", "separate", "", map[int][]int{5: {1}, 6: {2}}, map[int][]int{5: {3}}, nil},
+		{"", "drop", "user:pass", map[int][]int{5: {1}, 6: {2}}, nil, map[int][]int{5: {4}}},
+		{"post=6", "keep", "", map[int][]int{6: {2}}, nil, nil},
+	}
+	for _, tt := range tests {
+		setFor(t, &pingbacks, tt.pingbacks)
+		setFor(t, &auth, tt.auth)
+		comments, err := getComments(tt.query)
+		if err != nil {
+			t.Errorf("getComments(%q) failed: %v", tt.query, err)
+			continue
+		}
+		approved, pings, held := map[int][]int{}, map[int][]int{}, map[int][]int{}
+		for id, pc := range comments {
+			if len(pc.Approved) > 0 {
+				approved[id] = ids(pc.Approved)
+			}
+			if len(pc.Pings) > 0 {
+				pings[id] = ids(pc.Pings)
+			}
+			if len(pc.Status["hold"]) > 0 {
+				held[id] = ids(pc.Status["hold"])
+			}
+		}
+		for _, c := range []struct {
+			name      string
+			got, want map[int][]int
+		}{{"approved", approved, tt.approved}, {"pings", pings, tt.pings}, {"held", held, tt.held}} {
+			if !maps.EqualFunc(c.got, c.want, slices.Equal) {
+				t.Errorf("getComments(%q) with pingbacks %s, auth %q: %s = %v, want %v",
+					tt.query, tt.pingbacks, tt.auth, c.name, c.got, c.want)
+			}
+		}
+	}
+	if _, err := getComments("post=7"); err == nil {
+		t.Errorf("getComments(post=7) succeeded, want an error")
+	}
+}
+
+func TestApprovedComments(t *testing.T) {
+	comments := map[int]*PostComments{
+		5: {Approved: []Comment{{ID: 1}, {ID: 2}}},
+		6: {Status: map[string][]Comment{"hold": {{ID: 3}}}},
+		7: {Pings: []Comment{{ID: 4}}},
+	}
+	got := approvedComments(comments)
+	if len(got) != 1 || len(got[5]) != 2 {
+		t.Errorf("approvedComments = %v, want just the two comments on post 5", got)
+	}
+}
+
+func TestRouteUrl(t *testing.T) {
+	tests := []struct {
+		api   string
+		route string
+		want  string
+	}{
+		{"https://example.com/wp-json/", "wp/v2/posts?context=view", "https://example.com/wp-json/wp/v2/posts?context=view"},
+		{"https://example.com/wp-json/", "", "https://example.com/wp-json/"},
+		{"https://example.com/?rest_route=/", "wp/v2/posts?context=view&_fields=id,link",
+			"https://example.com/?_fields=id%2Clink&context=view&rest_route=%2Fwp%2Fv2%2Fposts"},
+		{"https://example.com/blog/?rest_route=/", "wp/v2/comments",
+			"https://example.com/blog/?rest_route=%2Fwp%2Fv2%2Fcomments"},
+		{"https://example.com/?rest_route=", "wp/v2/users", "https://example.com/?rest_route=%2Fwp%2Fv2%2Fusers"},
+		{"https://example.com/?rest_route=/", "", "https://example.com/?rest_route=%2F"},
+	}
+	for _, tt := range tests {
+		setFor(t, &apiUrl, tt.api)
+		got, err := routeUrl(tt.route)
+		if err != nil || got.String() != tt.want {
+			t.Errorf("routeUrl(%q) under %s = %v, %v, want %s", tt.route, tt.api, got, err, tt.want)
+		}
+	}
+}
+
+func TestIsApiRequest(t *testing.T) {
+	tests := []struct {
+		api  string
+		u    string
+		want bool
+	}{
+		{"https://example.com/wp-json/", "https://example.com/wp-json/wp/v2/posts", true},
+		{"https://example.com/wp-json/", "https://example.com/wp-content/uploads/a.jpg", false},
+		{"https://example.com/?rest_route=/", "https://example.com/?per_page=100&rest_route=%2Fwp%2Fv2%2Fposts", true},
+		{"https://example.com/?rest_route=/", "https://example.com/?p=12", false},
+		{"https://example.com/?rest_route=/", "https://cdn.example.com/?rest_route=/", false},
+	}
+	for _, tt := range tests {
+		setFor(t, &apiUrl, tt.api)
+		if got := isApiRequest(tt.u); got != tt.want {
+			t.Errorf("isApiRequest(%s) under %s = %v, want %v", tt.u, tt.api, got, tt.want)
+		}
+	}
+}
+
+func TestApiFromHeader(t *testing.T) {
+	tests := []struct {
+		links []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{`<https://example.com/wp-json/>; rel="https://api.w.org/"`}, "https://example.com/wp-json/"},
+		{[]string{`<https://example.com/?p=5>; rel=shortlink`, `<https://example.com/?rest_route=/>; rel="https://api.w.org/"`},
+			"https://example.com/?rest_route=/"},
+		{[]string{`<https://example.com/wp-json/wp/v2/posts/5>; rel="alternate"; type="application/json"`}, ""},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		for _, l := range tt.links {
+			header.Add("Link", l)
+		}
+		if got := apiFromHeader(header); got != tt.want {
+			t.Errorf("apiFromHeader(%q) = %q, want %q", tt.links, got, tt.want)
+		}
+	}
+}
+
+func TestFindApi(t *testing.T) {
+	apiIndex := func(w http.ResponseWriter) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"namespaces": []string{"wp/v2"}})
+	}
+	var server *httptest.Server
+	tests := []struct {
+		site    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"/header/", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Link", `<`+server.URL+`/header/wp-json/>; rel="https://api.w.org/"`)
+		}, "/header/wp-json/"},
+		{"/no-head/", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Header().Set("Link", `<`+server.URL+`/no-head/wp-json/>; rel="https://api.w.org/"`)
+		}, "/no-head/wp-json/"},
+		{"/link/", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`<html><head><link rel="https://api.w.org/" href="` + server.URL + `/link/api/"></head></html>`))
+		}, "/link/api/"},
+		{"/moved/", func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/moved/":
+				http.Redirect(w, r, "/moved/to/", http.StatusMovedPermanently)
+			case "/moved/to/wp-json/":
+				apiIndex(w)
+			}
+		}, "/moved/to/wp-json/"},
+		{"/plain/", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/plain/" && r.URL.Query().Get("rest_route") == "/" {
+				apiIndex(w)
+				return
+			}
+			if r.URL.Path != "/plain/" {
+				http.NotFound(w, r)
+			}
+		}, "/plain/?rest_route=/"},
+	}
+	mux := http.NewServeMux()
+	for _, tt := range tests {
+		mux.Handle(tt.site, tt.handler)
+	}
+	server = httptest.NewServer(mux)
+	defer server.Close()
+	setFor(t, &client, server.Client())
+	setFor(t, &rateLimit, 0)
+	for _, tt := range tests {
+		u, _ := url.Parse(server.URL + tt.site)
+		if got := findApi(u); got != server.URL+tt.want {
+			t.Errorf("findApi(%s) = %s, want %s", tt.site, strings.TrimPrefix(got, server.URL), tt.want)
+		}
+	}
+}
+
+func TestFetchRouteWithoutPermalinks(t *testing.T) {
+	testApi(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/" || q.Get("rest_route") != "/wp/v2/categories" || q.Get("_fields") != "id,name" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode([]map[string]any{{"id": 1, "name": "News"}})
+	})
+	setFor(t, &apiUrl, strings.TrimSuffix(apiUrl, "wp-json/")+"?rest_route=/")
+	var result []Category
+	err := fetchRoute("categories", &result, "wp/v2/categories?_fields=id,name")
+	if err != nil || len(result) != 1 || result[0].Name != "News" {
+		t.Errorf("fetchRoute through rest_route = %+v, %v, want the one category", result, err)
+	}
+}
+
+// pagedApi serves a listing of count items like WordPress does, with or
+// without saying how many pages there are, failing on page failPage
+func pagedApi(count int, headers bool, failPage int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		pages := (count + perPage - 1) / perPage
+		if page == failPage {
+			http.Error(w, `{"code":"oops","message":"broken"}`, http.StatusInternalServerError)
+			return
+		}
+		if page > max(pages, 1) {
+			http.Error(w, `{"code":"rest_post_invalid_page_number","message":"The page number requested is larger than the number of pages available."}`, http.StatusBadRequest)
+			return
+		}
+		if headers {
+			w.Header().Set("X-WP-Total", strconv.Itoa(count))
+			w.Header().Set("X-WP-TotalPages", strconv.Itoa(pages))
+		}
+		items := []map[string]any{}
+		for id := (page-1)*perPage + 1; id <= min(page*perPage, count); id++ {
+			items = append(items, map[string]any{"id": id})
+		}
+		_ = json.NewEncoder(w).Encode(items)
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	tests := []struct {
+		count    int
+		headers  bool
+		limit    int
+		failPage int
+		want     int
+	}{
+		{0, true, 0, 0, 0},
+		{42, true, 0, 0, 42},
+		{250, true, 0, 0, 250},
+		{250, false, 0, 0, 250},
+		{300, false, 0, 0, 300},
+		{1000, true, 150, 0, 150},
+		{1000, false, 150, 0, 150},
+		{1000, true, 30, 0, 30},
+		{350, true, 0, 3, -1},
+		{350, false, 0, 2, -1},
+	}
+	for _, tt := range tests {
+		testApi(t, pagedApi(tt.count, tt.headers, tt.failPage))
+		setFor(t, &concurrency, 3)
+		u, _ := routeUrl("wp/v2/posts")
+		items, err := getAll(u, Listing{Name: "posts", Limit: tt.limit, Quiet: true})
+		if tt.want < 0 {
+			if err == nil {
+				t.Errorf("getAll of %d items failing on page %d succeeded, want an error", tt.count, tt.failPage)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("getAll of %d items with headers %v, limit %d failed: %v", tt.count, tt.headers, tt.limit, err)
+			continue
+		}
+		if len(items) != tt.want {
+			t.Errorf("getAll of %d items with headers %v, limit %d = %d items, want %d", tt.count, tt.headers, tt.limit, len(items), tt.want)
+			continue
+		}
+		for i, item := range items {
+			if id := item.(map[string]interface{})["id"]; id != float64(i+1) {
+				t.Errorf("getAll of %d items with headers %v: item %d is %v, want them in order", tt.count, tt.headers, i, id)
+				break
+			}
+		}
+	}
+}
+
+func TestThousands(t *testing.T) {
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1000, "1,000"},
+		{123456, "123,456"},
+		{1234567, "1,234,567"},
+	}
+	for _, tt := range tests {
+		if got := thousands(tt.n); got != tt.want {
+			t.Errorf("thousands(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
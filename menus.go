@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type MenuItem struct {
+	ID       int         `json:"-" yaml:"-"`
+	Title    string      `json:"title" yaml:"title"`
+	URL      string      `json:"url" yaml:"url"`
+	Target   string      `json:"target,omitempty" yaml:"target,omitempty"`
+	Classes  []string    `json:"classes,omitempty" yaml:"classes,omitempty"`
+	Object   string      `json:"object,omitempty" yaml:"object,omitempty"`
+	ObjectID int         `json:"objectId,omitempty" yaml:"objectId,omitempty"`
+	Children []*MenuItem `json:"children,omitempty" yaml:"children,omitempty"`
+}
+
+type Menu struct {
+	ID        int         `json:"-" yaml:"-"`
+	Name      string      `json:"name" yaml:"name"`
+	Slug      string      `json:"slug" yaml:"slug"`
+	Locations []string    `json:"locations,omitempty" yaml:"locations,omitempty"`
+	Items     []*MenuItem `json:"items" yaml:"items"`
+}
+
+// writeMenus saves all the navigation menus we can find, with links to
+// posts and pages pointing at where we exported them
+func writeMenus() {
+	menus := getMenus()
+	if menus == nil {
+		warn("Couldn't find any navigation menus")
+		return
+	}
+
+	var rewrite func(items []*MenuItem)
+	rewrite = func(items []*MenuItem) {
+		for _, item := range items {
+			t := linkIndex.ByID(item.ObjectID)
+			if item.Object != "post" && item.Object != "page" {
+				t = nil
+				if u, err := url.Parse(item.URL); err == nil {
+					t = linkIndex.Lookup(u)
+				}
+			}
+			if t != nil && t.Exported {
+				item.URL = t.Path
+			} else {
+				item.URL = siteRelative(item.URL)
+			}
+			rewrite(item.Children)
+		}
+	}
+
+	result := map[string]*Menu{}
+	for _, m := range menus {
+		rewrite(m.Items)
+		result[m.Slug] = m
+	}
+
+	filename := filepath.Join(dest, "menus."+menuFormat)
+	var data []byte
+	var err error
+	if menuFormat == "json" {
+		data, err = json.MarshalIndent(result, "", "  ")
+	} else {
+		data, err = yaml.Marshal(result)
+	}
+	if err != nil {
+		fatal("Failed to encode %s: %v", filename, err)
+	}
+	err = writeFile(filename, data)
+	if err != nil {
+		fatal("Failed to write %s: %v", filename, err)
+	}
+	info("Saved %d menus", len(menus))
+}
+
+// getMenus tries the core menu endpoints, which need authentication, then
+// falls back to those added by the popular menu plugins
+func getMenus() []*Menu {
+	menus, err := getCoreMenus()
+	if err == nil {
+		return menus
+	}
+	if auth == "" {
+		info("Core menu API failed, it needs --auth: %v", err)
+	} else {
+		info("Core menu API failed: %v", err)
+	}
+	menus, err = getRestApiMenus()
+	if err == nil {
+		return menus
+	}
+	menus, err = getWpApiMenus()
+	if err == nil {
+		return menus
+	}
+	return nil
+}
+
+// Menus from wp/v2/menus, in WordPress 5.9 and later
+func getCoreMenus() ([]*Menu, error) {
+	var wpMenus []struct {
+		ID        int
+		Name      string
+		Slug      string
+		Locations []string
+	}
+	err := fetchRoute("menus", &wpMenus, "wp/v2/menus?context=view&_fields=id,name,slug,locations")
+	if err != nil {
+		return nil, err
+	}
+	var wpItems []struct {
+		ID        int
+		Title     Rendered
+		URL       string
+		Parent    int
+		MenuOrder int `mapstructure:"menu_order"`
+		Menus     int
+		Object    string
+		ObjectID  int `mapstructure:"object_id"`
+		Target    string
+		Classes   []string
+	}
+	err = fetchRoute("menu items", &wpItems, "wp/v2/menu-items?context=view&_fields=id,title,url,parent,menu_order,menus,object,object_id,target,classes")
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(wpItems, func(i, j int) bool { return wpItems[i].MenuOrder < wpItems[j].MenuOrder })
+
+	menus := []*Menu{}
+	menuByID := map[int]*Menu{}
+	for _, m := range wpMenus {
+		menu := &Menu{ID: m.ID, Name: m.Name, Slug: m.Slug, Locations: m.Locations}
+		menus = append(menus, menu)
+		menuByID[m.ID] = menu
+	}
+	itemByID := map[int]*MenuItem{}
+	for _, wi := range wpItems {
+		itemByID[wi.ID] = &MenuItem{
+			ID:       wi.ID,
+			Title:    htmlText(wi.Title.Rendered),
+			URL:      wi.URL,
+			Target:   wi.Target,
+			Classes:  nonEmpty(wi.Classes),
+			Object:   wi.Object,
+			ObjectID: wi.ObjectID,
+		}
+	}
+	for _, wi := range wpItems {
+		item := itemByID[wi.ID]
+		if parent, ok := itemByID[wi.Parent]; ok {
+			parent.Children = append(parent.Children, item)
+		} else if menu, ok := menuByID[wi.Menus]; ok {
+			menu.Items = append(menu.Items, item)
+		}
+	}
+	return menus, nil
+}
+
+// Menus from the WP REST API Menus plugin, at menus/v1
+func getRestApiMenus() ([]*Menu, error) {
+	var list []struct {
+		TermID int `mapstructure:"term_id"`
+		Name   string
+		Slug   string
+	}
+	err := getJSON("menus/v1/menus", &list)
+	if err != nil {
+		return nil, err
+	}
+	type pluginItem struct {
+		ID         int `mapstructure:"ID"`
+		Title      string
+		URL        string
+		Target     string
+		Classes    []string
+		Object     string
+		ObjectID   int          `mapstructure:"object_id"`
+		ChildItems []pluginItem `mapstructure:"child_items"`
+	}
+	var convert func(items []pluginItem) []*MenuItem
+	convert = func(items []pluginItem) []*MenuItem {
+		ret := []*MenuItem{}
+		for _, pi := range items {
+			ret = append(ret, &MenuItem{
+				ID:       pi.ID,
+				Title:    htmlText(pi.Title),
+				URL:      pi.URL,
+				Target:   pi.Target,
+				Classes:  nonEmpty(pi.Classes),
+				Object:   pi.Object,
+				ObjectID: pi.ObjectID,
+				Children: convert(pi.ChildItems),
+			})
+		}
+		return ret
+	}
+
+	menus := []*Menu{}
+	for _, m := range list {
+		var detail struct {
+			Items []pluginItem
+		}
+		err = getJSON("menus/v1/menus/"+url.PathEscape(m.Slug), &detail)
+		if err != nil {
+			return nil, err
+		}
+		menus = append(menus, &Menu{ID: m.TermID, Name: m.Name, Slug: m.Slug, Items: convert(detail.Items)})
+	}
+	return menus, nil
+}
+
+// Menus from the WP-API Menus plugin, at wp-api-menus/v2
+func getWpApiMenus() ([]*Menu, error) {
+	var list []struct {
+		ID   int `mapstructure:"ID"`
+		Name string
+		Slug string
+	}
+	err := getJSON("wp-api-menus/v2/menus", &list)
+	if err != nil {
+		return nil, err
+	}
+	type pluginItem struct {
+		ID       int
+		Title    string
+		URL      string
+		Target   string
+		Classes  string
+		Object   string
+		ObjectID int `mapstructure:"object_id"`
+		Children []pluginItem
+	}
+	var convert func(items []pluginItem) []*MenuItem
+	convert = func(items []pluginItem) []*MenuItem {
+		ret := []*MenuItem{}
+		for _, pi := range items {
+			ret = append(ret, &MenuItem{
+				ID:       pi.ID,
+				Title:    htmlText(pi.Title),
+				URL:      pi.URL,
+				Target:   pi.Target,
+				Classes:  strings.Fields(pi.Classes),
+				Object:   pi.Object,
+				ObjectID: pi.ObjectID,
+				Children: convert(pi.Children),
+			})
+		}
+		return ret
+	}
+
+	menus := []*Menu{}
+	for _, m := range list {
+		var detail struct {
+			Items []pluginItem
+		}
+		err = getJSON("wp-api-menus/v2/menus/"+strconv.Itoa(m.ID), &detail)
+		if err != nil {
+			return nil, err
+		}
+		menus = append(menus, &Menu{ID: m.ID, Name: m.Name, Slug: m.Slug, Items: convert(detail.Items)})
+	}
+	return menus, nil
+}
+
+// normaliseLink turns a link into something we can compare, ignoring the
+// scheme, any trailing slash and any query but the post or page ID, such as
+// tracking parameters
+func normaliseLink(link string) string {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return link
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	q := url.Values{}
+	for _, key := range []string{"p", "page_id"} {
+		if v := u.Query().Get(key); v != "" {
+			q.Set(key, v)
+		}
+	}
+	return host + strings.TrimSuffix(u.Path, "/") + "?" + q.Encode()
+}
+
+// siteRelative strips the scheme and host from links to the site we're
+// exporting
+func siteRelative(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || !u.IsAbs() || !sameSite(u) {
+		return link
+	}
+	u.Scheme = ""
+	u.Host = ""
+	u.User = nil
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String()
+}
+
+// sameSite checks whether a url is on the host we're exporting from
+func sameSite(u *url.URL) bool {
+	api, err := url.Parse(apiUrl)
+	if err != nil {
+		return false
+	}
+	if strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.") == strings.TrimPrefix(strings.ToLower(api.Hostname()), "www.") {
+		return true
+	}
+	return networkHost(u)
+}
+
+func nonEmpty(s []string) []string {
+	ret := []string{}
+	for _, v := range s {
+		if strings.TrimSpace(v) != "" {
+			ret = append(ret, v)
+		}
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	return ret
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormaliseLink(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"https://example.com/2020/01/post/", "example.com/2020/01/post?"},
+		{"http://www.Example.com/2020/01/post", "example.com/2020/01/post?"},
+		{"  https://example.com/about/  ", "example.com/about?"},
+		{"https://example.com/?p=12", "example.com?p=12"},
+		{"https://example.com/?page_id=7&utm_source=feed", "example.com?page_id=7"},
+		{"https://example.com/2020/01/post/?utm_source=x&utm_medium=y", "example.com/2020/01/post?"},
+		{"https://wp.me/p1-5", "wp.me/p1-5?"},
+	}
+	for _, tt := range tests {
+		if got := normaliseLink(tt.link); got != tt.want {
+			t.Errorf("normaliseLink(%q) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestSiteRelative(t *testing.T) {
+	setFor(t, &apiUrl, "https://example.com/wp-json/")
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"https://example.com/2020/01/post/", "/2020/01/post/"},
+		{"https://www.example.com", "/"},
+		{"https://example.com/?page_id=7", "/?page_id=7"},
+		{"https://elsewhere.example/page/", "https://elsewhere.example/page/"},
+		{"/already/relative/", "/already/relative/"},
+		{"mailto:someone@example.com", "mailto:someone@example.com"},
+	}
+	for _, tt := range tests {
+		if got := siteRelative(tt.link); got != tt.want {
+			t.Errorf("siteRelative(%q) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestNonEmpty(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want []string
+	}{
+		{nil, nil},
+		{[]string{"", " "}, nil},
+		{[]string{"menu-item", "", "current"}, []string{"menu-item", "current"}},
+	}
+	for _, tt := range tests {
+		if got := nonEmpty(tt.in); !slices.Equal(got, tt.want) {
+			t.Errorf("nonEmpty(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Site is a WordPress site we're exporting, perhaps one of many on a
+// multisite network
+type Site struct {
+	Slug string `json:"slug"`
+	URL  string `json:"url"`
+	Api  string `json:"api"`
+	// Where we save the site, and where it will live on the new site
+	Dest string `json:"-"`
+	Path string `json:"path"`
+
+	index      *SiteIndex
+	location   *time.Location
+	taxonomies []*Taxonomy
+	posts      []Post
+	exported   []Post
+	links      *LinkIndex
+}
+
+// The sites of the network we're exporting
+var sites []*Site
+
+// The path the site we're exporting will have on the new site, for networks
+var sitePath string
+
+// The path of the home page of the site we're exporting, on the old site
+var siteHome = "/"
+
+// The path of the site of a network we're exporting, which we strip off the
+// links of its posts unless --prefix is given
+var sitePrefix string
+
+// use makes s the site we're working on
+func (s *Site) use() {
+	apiUrl = s.Api
+	dest = s.Dest
+	sitePath = s.Path
+	linkIndex = s.links
+	customTaxonomies = s.taxonomies
+	siteLocation = s.location
+	siteHome = "/"
+	if s.URL != "" {
+		siteHome = oldPath(s.URL)
+	}
+	sitePrefix = strings.TrimSuffix(siteHome, "/")
+
+	// Things we remember that only make sense for one site
+	attachmentUrls = map[int]string{}
+	avatarFiles = map[string]string{}
+	rankMathAvailable = true
+	commentThreads = nil
+	badCommentDates = map[int]bool{}
+	redacted = Redactions{}
+}
+
+// exportNetwork exports each site of a multisite network into a directory
+// of its own, rewriting links between them
+func exportNetwork(root *url.URL, frontmatter string, filterRe *regexp.Regexp) int {
+	rootDest := dest
+	sites = networkSites(root)
+
+	// Find every post first, so that we know where links to other sites go
+	for _, s := range sites {
+		s.use()
+		info("Using API at %s for %s", s.Api, s.Slug)
+		loadSite(s, filterRe)
+	}
+	problems := 0
+	for _, s := range sites {
+		s.use()
+		info("Exporting %s", s.URL)
+		problems += exportSite(s, frontmatter)
+	}
+
+	dest = rootDest
+	sitePath = ""
+	writeMeta("sites", sites)
+	return problems
+}
+
+// NetworkSite is how plugins that list the sites of a network describe them
+type NetworkSite struct {
+	URL    string
+	Domain string
+	Path   string
+}
+
+// networkSites finds the sites we're exporting, either those given with
+// --sites or every site the API of the root site lists
+func networkSites(root *url.URL) []*Site {
+	urls := []string{}
+	for _, s := range siteList {
+		u, err := url.Parse(s)
+		if err != nil {
+			fatal("'%s' doesn't look like a site: %v", s, err)
+		}
+		if !u.IsAbs() {
+			// A path under the root of the network
+			u = root.ResolveReference(&url.URL{Path: strings.Trim(s, "/") + "/"})
+		}
+		urls = append(urls, u.String())
+	}
+
+	if len(urls) == 0 {
+		apiUrl = findApi(root)
+		if !strings.HasSuffix(apiUrl, "/") {
+			apiUrl += "/"
+		}
+		found := []NetworkSite{}
+		err := fetchRoute("sites", &found, "wp/v2/sites")
+		if err != nil {
+			fatal("Couldn't list the sites of the network, list them with --sites instead: %v", err)
+		}
+		for _, ns := range found {
+			if ns.URL != "" {
+				urls = append(urls, ns.URL)
+			} else if ns.Domain != "" {
+				urls = append(urls, root.Scheme+"://"+ns.Domain+ns.Path)
+			}
+		}
+	}
+	if len(urls) == 0 {
+		fatal("There are no sites to export")
+	}
+
+	ret := []*Site{}
+	slugs := map[string]string{}
+	for _, su := range urls {
+		u, err := parseURL(su)
+		if err != nil {
+			fatal("'%s' doesn't look like a url: %v", su, err)
+		}
+		if !strings.HasSuffix(u.Path, "/") {
+			u.Path += "/"
+		}
+		slug := siteSlug(u, root)
+		if other, ok := slugs[slug]; ok {
+			fatal("Sites %s and %s would both be saved as '%s'", other, u, slug)
+		}
+		slugs[slug] = u.String()
+		api := findApi(u)
+		if !strings.HasSuffix(api, "/") {
+			api += "/"
+		}
+		ret = append(ret, &Site{
+			Slug:  slug,
+			URL:   u.String(),
+			Api:   api,
+			Dest:  filepath.Join(dest, slug),
+			Path:  "/" + slug,
+			links: newLinkIndex(),
+		})
+	}
+	return ret
+}
+
+// siteSlug names a site of a network after its path, or its subdomain
+func siteSlug(u *url.URL, root *url.URL) string {
+	p := strings.Trim(strings.TrimPrefix(u.Path, root.Path), "/")
+	if p != "" {
+		return strings.ReplaceAll(p, "/", "-")
+	}
+	if !strings.EqualFold(u.Hostname(), root.Hostname()) {
+		label, _, _ := strings.Cut(u.Hostname(), ".")
+		return strings.ToLower(label)
+	}
+	return "main"
+}
+
+// networkIndex finds the links of the site of the network a url is on,
+// preferring the site with the longest matching path
+func networkIndex(u *url.URL) *LinkIndex {
+	var best *Site
+	bestLen := -1
+	for _, s := range sites {
+		su, err := url.Parse(s.URL)
+		if err != nil || !strings.EqualFold(su.Hostname(), u.Hostname()) {
+			continue
+		}
+		if (strings.HasPrefix(u.Path, su.Path) || u.Path+"/" == su.Path) && len(su.Path) > bestLen {
+			best = s
+			bestLen = len(su.Path)
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.links
+}
+
+// networkHost is whether a url is on any of the sites of the network
+func networkHost(u *url.URL) bool {
+	for _, s := range sites {
+		su, err := url.Parse(s.URL)
+		if err == nil && strings.EqualFold(su.Hostname(), u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestSiteSlug(t *testing.T) {
+	root, _ := url.Parse("https://example.com/")
+	tests := []struct {
+		site string
+		want string
+	}{
+		{"https://example.com/", "main"},
+		{"https://example.com/chemistry/", "chemistry"},
+		{"https://example.com/dept/physics/", "dept-physics"},
+		{"https://Biology.example.com/", "biology"},
+		{"https://EXAMPLE.com", "main"},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.site)
+		if got := siteSlug(u, root); got != tt.want {
+			t.Errorf("siteSlug(%s) = %q, want %q", tt.site, got, tt.want)
+		}
+	}
+}
+
+func TestNetworkIndex(t *testing.T) {
+	main := &Site{Slug: "main", URL: "https://example.com/", links: newLinkIndex()}
+	chemistry := &Site{Slug: "chemistry", URL: "https://example.com/chemistry/", links: newLinkIndex()}
+	biology := &Site{Slug: "biology", URL: "https://biology.example.com/", links: newLinkIndex()}
+	setFor(t, &sites, []*Site{main, chemistry, biology})
+	tests := []struct {
+		link string
+		want *Site
+	}{
+		{"https://example.com/2020/01/post/", main},
+		{"https://example.com/chemistry/2020/01/post/", chemistry},
+		{"https://example.com/chemistry", chemistry},
+		{"https://example.com/chemistry-news/", main},
+		{"https://BIOLOGY.example.com/about/", biology},
+		{"https://elsewhere.example/", nil},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.link)
+		var want *LinkIndex
+		if tt.want != nil {
+			want = tt.want.links
+		}
+		if got := networkIndex(u); got != want {
+			t.Errorf("networkIndex(%s) isn't the index of %v", tt.link, tt.want)
+		}
+		if got := networkHost(u); got != (tt.want != nil) {
+			t.Errorf("networkHost(%s) = %v, want %v", tt.link, got, tt.want != nil)
+		}
+	}
+}
+
+func TestSiteUse(t *testing.T) {
+	for _, v := range []*string{&apiUrl, &dest, &sitePath, &siteHome, &sitePrefix} {
+		setFor(t, v, *v)
+	}
+	setFor(t, &linkIndex, linkIndex)
+	setFor(t, &customTaxonomies, customTaxonomies)
+	setFor(t, &siteLocation, siteLocation)
+	setFor(t, &avatarFiles, avatarFiles)
+	setFor(t, &commentThreads, commentThreads)
+	setFor(t, &badCommentDates, badCommentDates)
+	setFor(t, &redacted, redacted)
+	setFor(t, &attachmentUrls, map[int]string{5: "left over"})
+	setFor(t, &rankMathAvailable, false)
+	tests := []struct {
+		site   Site
+		home   string
+		prefix string
+	}{
+		{Site{URL: "https://example.com/chemistry/", Api: "https://example.com/chemistry/wp-json/", Dest: "out/chemistry", Path: "/chemistry"},
+			"/chemistry/", "/chemistry"},
+		{Site{URL: "https://example.com/"}, "/", ""},
+		{Site{}, "/", ""},
+	}
+	for _, tt := range tests {
+		tt.site.links = newLinkIndex()
+		tt.site.use()
+		if apiUrl != tt.site.Api || dest != tt.site.Dest || sitePath != tt.site.Path || linkIndex != tt.site.links {
+			t.Errorf("using %s didn't switch to it", tt.site.URL)
+		}
+		if siteHome != tt.home || sitePrefix != tt.prefix {
+			t.Errorf("using %s gave home %q and prefix %q, want %q and %q", tt.site.URL, siteHome, sitePrefix, tt.home, tt.prefix)
+		}
+		if len(attachmentUrls) != 0 || !rankMathAvailable {
+			t.Errorf("using %s kept what we knew about the last site", tt.site.URL)
+		}
+	}
+}
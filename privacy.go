@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"strings"
+)
+
+// Comment privacy policies, from least to most private
+var privacyPolicies = []string{"none", "safe", "anonymous"}
+
+// Emails of commenters who are happy to be contacted, so keep their email,
+// name and url
+var allowContact = map[string]bool{}
+
+type Redactions struct {
+	Emails     int
+	IPs        int
+	UserAgents int
+	Names      int
+	URLs       int
+}
+
+var redacted Redactions
+
+// anonymousSalt is mixed into the IDs we give anonymous commenters, so they
+// can't be matched to an email or to gravatar. It's random unless given with
+// --anonymous-salt, and kept in the checkpoint so they don't change when we
+// resume.
+var anonymousSalt string
+
+func randomSalt() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func readAllowContact(filename string) {
+	file, err := os.Open(filename)
+	if err != nil {
+		fatal("Failed to open '%s': %v", filename, err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		allowContact[strings.ToLower(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		fatal("Failed to read from '%s': %v", filename, err)
+	}
+}
+
+// redactComments removes personal information from comments, according
+// to the privacy policy
+func redactComments(comments []Comment) {
+	if commentPrivacy == "none" {
+		return
+	}
+	for i := range comments {
+		c := &comments[i]
+		if c.AuthorIP != "" {
+			c.AuthorIP = ""
+			redacted.IPs++
+		}
+		if c.AuthorUserAgent != "" {
+			c.AuthorUserAgent = ""
+			redacted.UserAgents++
+		}
+		email := strings.ToLower(strings.TrimSpace(c.AuthorEmail))
+		if allowContact[email] {
+			continue
+		}
+		if c.AuthorEmail != "" {
+			if commentPrivacy == "anonymous" {
+				c.AuthorEmailHash = anonymousID(email)
+			} else {
+				c.AuthorEmailHash = md5Hex(email)
+			}
+			c.AuthorEmail = ""
+			redacted.Emails++
+		}
+		if commentPrivacy == "anonymous" {
+			if c.AuthorName != "" {
+				c.AuthorName = "Commenter " + anonymousID(email + "\x00" + c.AuthorName)[:6]
+				redacted.Names++
+			}
+			if c.AuthorURL != "" {
+				c.AuthorURL = ""
+				redacted.URLs++
+			}
+			// Avatar urls are gravatar hashes of the email
+			c.AuthorAvatarURLs = nil
+		}
+	}
+}
+
+func reportRedactions() {
+	if commentPrivacy == "none" {
+		return
+	}
+	info("Redacted comments: %d emails hashed, %d IP addresses, %d user agents, %d names and %d urls removed",
+		redacted.Emails, redacted.IPs, redacted.UserAgents, redacted.Names, redacted.URLs)
+}
+
+// anonymousID identifies a commenter within exports with the same salt
+func anonymousID(s string) string {
+	return sha1Hex(anonymousSalt + "\x00" + s)
+}
+
+// The gravatar hash of a commenter's email, whether or not we've redacted it,
+// or their anonymous ID
+func commentEmailHash(c Comment) string {
+	if c.AuthorEmail != "" {
+		return md5Hex(strings.ToLower(strings.TrimSpace(c.AuthorEmail)))
+	}
+	return c.AuthorEmailHash
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testComment() Comment {
+	return Comment{
+		ID:               1,
+		AuthorEmail:      " Alice@Example.com ",
+		AuthorIP:         "192.0.2.1",
+		AuthorName:       "Alice",
+		AuthorURL:        "https://alice.example/",
+		AuthorUserAgent:  "Mozilla/5.0",
+		AuthorAvatarURLs: map[string]string{"96": "https://gravatar.example/avatar/x"},
+	}
+}
+
+func TestRedactComments(t *testing.T) {
+	setFor(t, &anonymousSalt, "pepper")
+	gravatar := md5Hex("alice@example.com")
+	anonymous := anonymousID("alice@example.com")
+	tests := []struct {
+		policy  string
+		allowed bool
+		want    Comment
+		counts  Redactions
+	}{
+		{"none", false, testComment(), Redactions{}},
+		{"safe", false, Comment{
+			ID:               1,
+			AuthorEmailHash:  gravatar,
+			AuthorName:       "Alice",
+			AuthorURL:        "https://alice.example/",
+			AuthorAvatarURLs: map[string]string{"96": "https://gravatar.example/avatar/x"},
+		}, Redactions{Emails: 1, IPs: 1, UserAgents: 1}},
+		{"anonymous", false, Comment{
+			ID:              1,
+			AuthorEmailHash: anonymous,
+			AuthorName:      "Commenter " + anonymousID("alice@example.com\x00Alice")[:6],
+		}, Redactions{Emails: 1, IPs: 1, UserAgents: 1, Names: 1, URLs: 1}},
+		{"anonymous", true, Comment{
+			ID:               1,
+			AuthorEmail:      " Alice@Example.com ",
+			AuthorName:       "Alice",
+			AuthorURL:        "https://alice.example/",
+			AuthorAvatarURLs: map[string]string{"96": "https://gravatar.example/avatar/x"},
+		}, Redactions{IPs: 1, UserAgents: 1}},
+	}
+	for _, tt := range tests {
+		setFor(t, &commentPrivacy, tt.policy)
+		setFor(t, &redacted, Redactions{})
+		setFor(t, &allowContact, map[string]bool{})
+		if tt.allowed {
+			allowContact["alice@example.com"] = true
+		}
+		comments := []Comment{testComment()}
+		redactComments(comments)
+		got := comments[0]
+		if got.AuthorEmail != tt.want.AuthorEmail || got.AuthorEmailHash != tt.want.AuthorEmailHash ||
+			got.AuthorIP != tt.want.AuthorIP || got.AuthorUserAgent != tt.want.AuthorUserAgent ||
+			got.AuthorName != tt.want.AuthorName || got.AuthorURL != tt.want.AuthorURL ||
+			len(got.AuthorAvatarURLs) != len(tt.want.AuthorAvatarURLs) {
+			t.Errorf("redactComments with %s policy, allowed %v = %+v, want %+v", tt.policy, tt.allowed, got, tt.want)
+		}
+		if redacted != tt.counts {
+			t.Errorf("redactComments with %s policy, allowed %v counted %+v, want %+v", tt.policy, tt.allowed, redacted, tt.counts)
+		}
+	}
+}
+
+func TestAnonymousID(t *testing.T) {
+	setFor(t, &anonymousSalt, "pepper")
+	first := anonymousID("alice@example.com")
+	if anonymousID("alice@example.com") != first {
+		t.Errorf("anonymousID isn't stable for the same salt")
+	}
+	if anonymousID("bob@example.com") == first {
+		t.Errorf("anonymousID is the same for different commenters")
+	}
+	if first == sha1Hex("alice@example.com") || first == md5Hex("alice@example.com") {
+		t.Errorf("anonymousID isn't salted")
+	}
+	anonymousSalt = "salt"
+	if anonymousID("alice@example.com") == first {
+		t.Errorf("anonymousID is the same for different salts")
+	}
+}
+
+func TestCommentEmailHash(t *testing.T) {
+	tests := []struct {
+		comment Comment
+		want    string
+	}{
+		{Comment{AuthorEmail: " Alice@Example.com"}, md5Hex("alice@example.com")},
+		{Comment{AuthorEmailHash: "abc123"}, "abc123"},
+		{Comment{}, ""},
+	}
+	for _, tt := range tests {
+		if got := commentEmailHash(tt.comment); got != tt.want {
+			t.Errorf("commentEmailHash(%+v) = %q, want %q", tt.comment, got, tt.want)
+		}
+	}
+}
+
+func TestReadAllowContact(t *testing.T) {
+	setFor(t, &allowContact, map[string]bool{})
+	filename := filepath.Join(t.TempDir(), "allow.txt")
+	err := os.WriteFile(filename, []byte("# people happy to be contacted\n  Alice@Example.com \n\nbob@example.com\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAllowContact(filename)
+	want := map[string]bool{"alice@example.com": true, "bob@example.com": true}
+	if !maps.Equal(allowContact, want) {
+		t.Errorf("readAllowContact = %v, want %v", allowContact, want)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress shows how far through the export we are: stacked bars when
+// stderr is a terminal, and a plain line every so often when it isn't
+type Progress struct {
+	bars     []*Bar
+	activity string
+	tty      bool
+	// Whether we log progress as events rather than printing it, when
+	// they'd otherwise be mixed up on stderr
+	events bool
+	// How many lines of bars are on the screen
+	lines    int
+	lastDraw time.Time
+	lastLog  time.Time
+	finished bool
+}
+
+// Bar counts one kind of thing we're working through
+type Bar struct {
+	Name  string
+	Done  int
+	Total int
+	Bytes int64
+	start time.Time
+}
+
+// Pages of listings are fetched in parallel, so output needs to take turns
+var outputMu sync.Mutex
+
+var progress = &Progress{tty: isTerminal(os.Stderr)}
+
+var pagesBar = progress.bar("API pages")
+var postsBar = progress.bar("posts")
+var assetsBar = progress.bar("assets")
+
+// How often we redraw the bars, or log progress when we can't draw them
+const drawInterval = 100 * time.Millisecond
+const logInterval = 10 * time.Second
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *Progress) bar(name string) *Bar {
+	b := &Bar{Name: name}
+	p.bars = append(p.bars, b)
+	return b
+}
+
+// AddTotal says there are n more things to do
+func (b *Bar) AddTotal(n int) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	b.started()
+	b.Total += n
+	progress.drawLocked(false)
+}
+
+// Add says we've done n more things
+func (b *Bar) Add(n int) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	b.started()
+	b.Done += n
+	progress.drawLocked(false)
+}
+
+// AddBytes counts the size of what we've done, for downloads
+func (b *Bar) AddBytes(n int64) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	b.started()
+	b.Bytes += n
+	progress.drawLocked(false)
+}
+
+func (b *Bar) started() {
+	if b.start.IsZero() {
+		b.start = time.Now()
+	}
+}
+
+func (b *Bar) String() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "%-10s ", b.Name)
+	if b.Total > 0 {
+		const width = 30
+		filled := min(width, width*b.Done/b.Total)
+		fmt.Fprintf(&s, "[%s%s] %s/%s %3d%%", strings.Repeat("#", filled), strings.Repeat("-", width-filled),
+			thousands(b.Done), thousands(b.Total), 100*b.Done/b.Total)
+		if b.Done > 0 && b.Done < b.Total {
+			eta := time.Since(b.start) / time.Duration(b.Done) * time.Duration(b.Total-b.Done)
+			fmt.Fprintf(&s, " %s left", eta.Round(time.Second))
+		}
+	} else {
+		s.WriteString(thousands(b.Done))
+	}
+	if b.Bytes > 0 {
+		fmt.Fprintf(&s, " (%s)", humanBytes(b.Bytes))
+	}
+	return s.String()
+}
+
+// summary is all the bars on one line, for logs
+func (p *Progress) summary() string {
+	parts := []string{}
+	for _, b := range p.bars {
+		if b.start.IsZero() {
+			continue
+		}
+		part := b.Name + " " + thousands(b.Done)
+		if b.Total > 0 {
+			part += "/" + thousands(b.Total)
+		}
+		if b.Bytes > 0 {
+			part += " (" + humanBytes(b.Bytes) + ")"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// attrs is all the bars as attributes of a log event
+func (p *Progress) attrs() []slog.Attr {
+	attrs := []slog.Attr{}
+	for _, b := range p.bars {
+		if b.start.IsZero() {
+			continue
+		}
+		bar := []any{slog.Int("done", b.Done)}
+		if b.Total > 0 {
+			bar = append(bar, slog.Int("total", b.Total))
+		}
+		if b.Bytes > 0 {
+			bar = append(bar, slog.Int64("bytes", b.Bytes))
+		}
+		attrs = append(attrs, slog.Group(b.Name, bar...))
+	}
+	return attrs
+}
+
+// drawLocked shows the bars, if it's been long enough since we last did.
+// The caller holds outputMu.
+func (p *Progress) drawLocked(force bool) {
+	if quiet || p.finished {
+		return
+	}
+	now := time.Now()
+	if !p.tty {
+		if (force || now.Sub(p.lastLog) >= logInterval) && p.summary() != "" {
+			p.lastLog = now
+			if p.events {
+				logEventLocked(slog.LevelInfo, "progress", p.attrs()...)
+			} else {
+				_, _ = fmt.Fprintf(os.Stderr, "progress: %s\n", p.summary())
+			}
+		}
+		return
+	}
+	if !force && now.Sub(p.lastDraw) < drawInterval {
+		return
+	}
+	p.lastDraw = now
+	p.clearLocked()
+	for _, b := range p.bars {
+		if b.start.IsZero() {
+			continue
+		}
+		_, _ = io.WriteString(os.Stderr, b.String()+"\n")
+		p.lines++
+	}
+	if p.activity != "" {
+		_, _ = io.WriteString(os.Stderr, p.activity+"\n")
+		p.lines++
+	}
+}
+
+// clearLocked takes the bars off the screen, so that we can print a message
+// in their place. The caller holds outputMu.
+func (p *Progress) clearLocked() {
+	if !p.tty {
+		return
+	}
+	_, _ = io.WriteString(os.Stderr, strings.Repeat("\x1b[1A\x1b[2K", p.lines))
+	p.lines = 0
+}
+
+// restoreLocked puts the bars back after printing a message
+func (p *Progress) restoreLocked() {
+	if p.tty {
+		p.drawLocked(true)
+	}
+}
+
+// finish leaves the final state of the bars on the screen, or in the log
+func (p *Progress) finish() {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	p.activity = ""
+	p.drawLocked(true)
+	p.lines = 0
+	p.finished = true
+}
+
+// status describes what we're doing right now, under the bars
+func status(format string, a ...interface{}) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	progress.activity = fmt.Sprintf(format, a...)
+	progress.drawLocked(false)
+}
+
+func endStatus(format string, a ...interface{}) {
+	outputMu.Lock()
+	progress.activity = ""
+	outputMu.Unlock()
+	info(format, a...)
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestHumanBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{5 * 1024 * 1024, "5.0 MB"},
+		{3 << 30, "3.0 GB"},
+	}
+	for _, tt := range tests {
+		if got := humanBytes(tt.n); got != tt.want {
+			t.Errorf("humanBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestBarString(t *testing.T) {
+	started := time.Now().Add(-10 * time.Second)
+	tests := []struct {
+		bar  Bar
+		want string
+	}{
+		{Bar{Name: "posts"}, "posts      0"},
+		{Bar{Name: "assets", Done: 1234, Bytes: 2048}, "assets     1,234 (2.0 KB)"},
+		{Bar{Name: "posts", Done: 0, Total: 10}, "posts      [------------------------------] 0/10   0%"},
+		{Bar{Name: "posts", Done: 5, Total: 10, start: started}, "posts      [###############---------------] 5/10  50% 10s left"},
+		{Bar{Name: "API pages", Done: 3000, Total: 3000}, "API pages  [##############################] 3,000/3,000 100%"},
+	}
+	for _, tt := range tests {
+		if got := tt.bar.String(); got != tt.want {
+			t.Errorf("Bar.String() =\n%q\nwant\n%q", got, tt.want)
+		}
+	}
+}
+
+func testProgress() *Progress {
+	p := &Progress{}
+	pages := p.bar("API pages")
+	posts := p.bar("posts")
+	p.bar("assets")
+	pages.started()
+	pages.Done, pages.Total = 3, 10
+	posts.started()
+	posts.Done, posts.Bytes = 1200, 1536
+	return p
+}
+
+func TestProgressSummary(t *testing.T) {
+	want := "API pages 3/10, posts 1,200 (1.5 KB)"
+	if got := testProgress().summary(); got != want {
+		t.Errorf("summary() = %q, want %q", got, want)
+	}
+	if got := (&Progress{}).summary(); got != "" {
+		t.Errorf("summary() before we start = %q, want nothing", got)
+	}
+}
+
+func TestProgressAttrs(t *testing.T) {
+	want := []slog.Attr{
+		slog.Group("API pages", slog.Int("done", 3), slog.Int("total", 10)),
+		slog.Group("posts", slog.Int("done", 1200), slog.Int64("bytes", 1536)),
+	}
+	got := testProgress().attrs()
+	if len(got) != len(want) {
+		t.Fatalf("attrs() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("attrs()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Redirect maps an old WordPress url to where it lives on the new site
+type Redirect struct {
+	From string
+	To   string
+}
+
+var redirectFormats = map[string]func([]Redirect) (string, []byte){
+	"netlify": netlifyRedirects,
+	"vercel":  vercelRedirects,
+	"nginx":   nginxRedirects,
+	"apache":  apacheRedirects,
+	// hugo redirects are written as aliases in the frontmatter of each post
+	"hugo": nil,
+}
+
+// buildRedirects works out every old url we know of for the posts we're
+// exporting, and for the category and author archives we have pages for. It returns the
+// redirects, and the old paths of each post for use as aliases.
+func buildRedirects(posts []Post, users map[int]*User, categories map[int]*Category) ([]Redirect, map[int][]string) {
+	redirects := []Redirect{}
+	aliases := map[int][]string{}
+	seen := map[string]string{}
+	add := func(from string, to string, postID int) {
+		if from == "" || from == to || strings.TrimSuffix(from, "/") == strings.TrimSuffix(to, "/") {
+			return
+		}
+		if existing, ok := seen[from]; ok {
+			if existing != to {
+				warn("Not redirecting %s to %s, it already redirects to %s", from, to, existing)
+			}
+			return
+		}
+		seen[from] = to
+		redirects = append(redirects, Redirect{From: from, To: to})
+		if postID != 0 && !strings.Contains(from, "?") {
+			aliases[postID] = append(aliases[postID], from)
+		}
+	}
+
+	newPaths := map[int]string{}
+	for _, p := range posts {
+		newPath := postUrlPath(p)
+		newPaths[p.ID] = newPath
+		add(oldPath(p.Link), newPath, p.ID)
+		add(fmt.Sprintf("%s?p=%d", siteHome, p.ID), newPath, p.ID)
+		if p.Slug != "" {
+			add(siteHome+p.Slug+"/", newPath, p.ID)
+		}
+	}
+
+	media, err := attachedMedia(slices.Sorted(maps.Keys(newPaths)))
+	if err != nil {
+		warn("Not redirecting attachment pages: %v", err)
+	}
+	for _, m := range media {
+		newPath, ok := newPaths[m.Post]
+		if !ok {
+			continue
+		}
+		add(oldPath(m.Link), newPath, m.Post)
+		add(fmt.Sprintf("%s?attachment_id=%d", siteHome, m.ID), newPath, m.Post)
+	}
+
+	// Archives only have somewhere to go if we've saved a landing page for
+	// them, and we never do for tags or custom taxonomies
+	if saveCategories {
+		for _, id := range slices.Sorted(maps.Keys(categories)) {
+			c := categories[id]
+			newPath := termUrlPath("categories", categoryPath(categories, id))
+			add(oldPath(c.Link), newPath, 0)
+			add(fmt.Sprintf("%s?cat=%d", siteHome, c.ID), newPath, 0)
+		}
+	}
+	if saveAuthors {
+		bylines := map[string]bool{}
+		for _, p := range posts {
+			for _, b := range p.Bylines {
+				bylines[b.Slug] = true
+			}
+		}
+		for _, id := range slices.Sorted(maps.Keys(users)) {
+			u := users[id]
+			if u.Slug == "" || !bylines[u.Slug] {
+				continue
+			}
+			add(oldPath(u.Link), termUrlPath("authors", u.Slug), 0)
+			add(fmt.Sprintf("%s?author=%d", siteHome, u.ID), termUrlPath("authors", u.Slug), 0)
+		}
+	}
+	return redirects, aliases
+}
+
+// How many posts we ask for the attachments of at once, to keep urls short
+const attachedBatch = 50
+
+// attachedMedia finds the attachments of the posts we're exporting
+func attachedMedia(ids []int) ([]Media, error) {
+	ret := []Media{}
+	for batch := range slices.Chunk(ids, attachedBatch) {
+		parents := []string{}
+		for _, id := range batch {
+			parents = append(parents, strconv.Itoa(id))
+		}
+		media, err := fetchMedia("parent=" + strings.Join(parents, ","))
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, media...)
+	}
+	return ret, nil
+}
+
+// The path, and query if any, of an old url
+func oldPath(link string) string {
+	if link == "" {
+		return ""
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		warn("Failed to parse url '%s': %v", link, err)
+		return ""
+	}
+	ret := u.EscapedPath()
+	if ret == "" {
+		ret = "/"
+	}
+	if u.RawQuery != "" {
+		ret += "?" + u.RawQuery
+	}
+	return ret
+}
+
+// The url path of a taxonomy archive on the new site
+func termUrlPath(taxonomy string, slug string) string {
+	return sitePath + "/" + taxonomy + "/" + slug + "/"
+}
+
+func writeRedirects(redirects []Redirect) {
+	dir := filepath.Join(dest, "redirects")
+	for _, format := range redirectList {
+		writer := redirectFormats[format]
+		if writer == nil {
+			continue
+		}
+		_ = os.MkdirAll(dir, 0755)
+		name, data := writer(redirects)
+		filename := filepath.Join(dir, name)
+		err := writeFile(filename, data)
+		if err != nil {
+			fatal("Failed to write %s: %v", filename, err)
+		}
+	}
+	info("Saved %d redirects", len(redirects))
+}
+
+func splitQuery(from string) (string, string) {
+	p, q, _ := strings.Cut(from, "?")
+	return p, q
+}
+
+func netlifyRedirects(redirects []Redirect) (string, []byte) {
+	var b strings.Builder
+	for _, r := range redirects {
+		p, q := splitQuery(r.From)
+		if q != "" {
+			fmt.Fprintf(&b, "%s  %s  %s  301!\n", p, strings.ReplaceAll(q, "&", " "), r.To)
+		} else {
+			fmt.Fprintf(&b, "%s  %s  301!\n", p, r.To)
+		}
+	}
+	return "_redirects", []byte(b.String())
+}
+
+func vercelRedirects(redirects []Redirect) (string, []byte) {
+	type has struct {
+		Type  string `json:"type"`
+		Key   string `json:"key"`
+		Value string `json:"value,omitempty"`
+	}
+	type route struct {
+		Src     string            `json:"src"`
+		Has     []has             `json:"has,omitempty"`
+		Status  int               `json:"status"`
+		Headers map[string]string `json:"headers"`
+	}
+	routes := []route{}
+	for _, r := range redirects {
+		p, q := splitQuery(r.From)
+		rt := route{
+			Src:     "^" + pathPattern(p) + "$",
+			Status:  301,
+			Headers: map[string]string{"Location": r.To},
+		}
+		if q != "" {
+			values, _ := url.ParseQuery(q)
+			for k, v := range values {
+				rt.Has = append(rt.Has, has{Type: "query", Key: k, Value: "^" + regexp.QuoteMeta(v[0]) + "$"})
+			}
+		}
+		routes = append(routes, rt)
+	}
+	data, err := json.MarshalIndent(map[string]interface{}{"routes": routes}, "", "  ")
+	if err != nil {
+		fatal("Failed to encode vercel redirects: %v", err)
+	}
+	return "vercel.json", append(data, '\n')
+}
+
+func nginxRedirects(redirects []Redirect) (string, []byte) {
+	var b strings.Builder
+	b.WriteString("# Include in your http block, then in your server block use\n")
+	b.WriteString("#   if ($wordpress_redirect) { return 301 $wordpress_redirect; }\n")
+	b.WriteString("map $request_uri $wordpress_redirect {\n")
+	for _, r := range redirects {
+		p, q := splitQuery(r.From)
+		if q != "" {
+			fmt.Fprintf(&b, "    %s %s;\n", nginxQuote(r.From), nginxQuote(r.To))
+		} else {
+			fmt.Fprintf(&b, "    %s %s;\n", nginxQuote("~^"+pathPattern(p)+`(\?.*)?$`), nginxQuote(r.To))
+		}
+	}
+	b.WriteString("}\n")
+	return "nginx.conf", []byte(b.String())
+}
+
+func nginxQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func apacheRedirects(redirects []Redirect) (string, []byte) {
+	var b strings.Builder
+	b.WriteString("RewriteEngine On\n")
+	for _, r := range redirects {
+		p, q := splitQuery(r.From)
+		pattern := "^" + strings.TrimPrefix(pathPattern(p), "/") + "$"
+		if q != "" {
+			fmt.Fprintf(&b, "RewriteCond %%{QUERY_STRING} ^%s$\n", regexp.QuoteMeta(q))
+			fmt.Fprintf(&b, "RewriteRule %s %s? [R=301,L]\n", pattern, r.To)
+		} else {
+			fmt.Fprintf(&b, "RewriteRule %s %s [R=301,L]\n", pattern, r.To)
+		}
+	}
+	return ".htaccess", []byte(b.String())
+}
+
+// pathPattern is a regular expression matching a path with or without
+// a trailing slash
+func pathPattern(p string) string {
+	if p == "/" {
+		return "/"
+	}
+	return regexp.QuoteMeta(strings.TrimSuffix(p, "/")) + "/?"
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+var testRedirects = []Redirect{
+	{From: "/2020/01/post/", To: "/posts/post/"},
+	{From: "/?p=12", To: "/posts/post/"},
+	{From: "/old/page.html", To: "/page/"},
+}
+
+func TestRedirectWriters(t *testing.T) {
+	tests := []struct {
+		format   string
+		filename string
+		want     string
+	}{
+		{"netlify", "_redirects", "" +
+			"/2020/01/post/  /posts/post/  301!\n" +
+			"/  p=12  /posts/post/  301!\n" +
+			"/old/page.html  /page/  301!\n"},
+		{"nginx", "nginx.conf", "" +
+			"# Include in your http block, then in your server block use\n" +
+			"#   if ($wordpress_redirect) { return 301 $wordpress_redirect; }\n" +
+			"map $request_uri $wordpress_redirect {\n" +
+			`    "~^/2020/01/post/?(\\?.*)?$" "/posts/post/";` + "\n" +
+			`    "/?p=12" "/posts/post/";` + "\n" +
+			`    "~^/old/page\\.html/?(\\?.*)?$" "/page/";` + "\n" +
+			"}\n"},
+		{"apache", ".htaccess", "" +
+			"RewriteEngine On\n" +
+			"RewriteRule ^2020/01/post/?$ /posts/post/ [R=301,L]\n" +
+			"RewriteCond %{QUERY_STRING} ^p=12$\n" +
+			"RewriteRule ^$ /posts/post/? [R=301,L]\n" +
+			`RewriteRule ^old/page\.html/?$ /page/ [R=301,L]` + "\n"},
+	}
+	for _, tt := range tests {
+		filename, data := redirectFormats[tt.format](testRedirects)
+		if filename != tt.filename {
+			t.Errorf("%s redirects saved as %q, want %q", tt.format, filename, tt.filename)
+		}
+		if string(data) != tt.want {
+			t.Errorf("%s redirects =\n%s\nwant\n%s", tt.format, data, tt.want)
+		}
+	}
+}
+
+func TestVercelRedirects(t *testing.T) {
+	type has struct {
+		Type  string
+		Key   string
+		Value string
+	}
+	type route struct {
+		Src     string
+		Has     []has
+		Status  int
+		Headers map[string]string
+	}
+	filename, data := vercelRedirects(testRedirects)
+	if filename != "vercel.json" {
+		t.Errorf("vercel redirects saved as %q, want vercel.json", filename)
+	}
+	var got struct {
+		Routes []route
+	}
+	err := json.Unmarshal(data, &got)
+	if err != nil {
+		t.Fatalf("vercel redirects aren't json: %v", err)
+	}
+	want := []route{
+		{Src: "^/2020/01/post/?$", Status: 301, Headers: map[string]string{"Location": "/posts/post/"}},
+		{Src: "^/$", Has: []has{{Type: "query", Key: "p", Value: "^12$"}}, Status: 301, Headers: map[string]string{"Location": "/posts/post/"}},
+		{Src: `^/old/page\.html/?$`, Status: 301, Headers: map[string]string{"Location": "/page/"}},
+	}
+	if !reflect.DeepEqual(got.Routes, want) {
+		t.Errorf("vercel routes = %+v, want %+v", got.Routes, want)
+	}
+}
+
+func TestOldPath(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"", ""},
+		{"https://example.com", "/"},
+		{"https://example.com/2020/01/post/", "/2020/01/post/"},
+		{"https://example.com/blog/?p=12", "/blog/?p=12"},
+		{"https://example.com/caf%C3%A9/", "/caf%C3%A9/"},
+	}
+	for _, tt := range tests {
+		if got := oldPath(tt.link); got != tt.want {
+			t.Errorf("oldPath(%q) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestPathPattern(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "/"},
+		{"/about/", "/about/?"},
+		{"/about", "/about/?"},
+		{"/page.html", `/page\.html/?`},
+	}
+	for _, tt := range tests {
+		if got := pathPattern(tt.path); got != tt.want {
+			t.Errorf("pathPattern(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestTermUrlPath(t *testing.T) {
+	tests := []struct {
+		sitePath string
+		taxonomy string
+		slug     string
+		want     string
+	}{
+		{"", "categories", "news", "/categories/news/"},
+		{"", "categories", "news/local", "/categories/news/local/"},
+		{"/chemistry", "authors", "alice", "/chemistry/authors/alice/"},
+	}
+	for _, tt := range tests {
+		setFor(t, &sitePath, tt.sitePath)
+		if got := termUrlPath(tt.taxonomy, tt.slug); got != tt.want {
+			t.Errorf("termUrlPath(%q, %q) on %q = %q, want %q", tt.taxonomy, tt.slug, tt.sitePath, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// SEO is the metadata search engines and social sites see, from Yoast,
+// Rank Math or All in One SEO
+type SEO struct {
+	Title              string `yaml:"title,omitempty"`
+	Description        string `yaml:"description,omitempty"`
+	Canonical          string `yaml:"canonical,omitempty"`
+	NoIndex            bool   `yaml:"noindex,omitempty"`
+	NoFollow           bool   `yaml:"nofollow,omitempty"`
+	OGTitle            string `yaml:"ogTitle,omitempty"`
+	OGDescription      string `yaml:"ogDescription,omitempty"`
+	OGImage            string `yaml:"ogImage,omitempty"`
+	TwitterCard        string `yaml:"twitterCard,omitempty"`
+	TwitterTitle       string `yaml:"twitterTitle,omitempty"`
+	TwitterDescription string `yaml:"twitterDescription,omitempty"`
+	TwitterImage       string `yaml:"twitterImage,omitempty"`
+}
+
+// Rank Math only has its own endpoint, and only with headless support turned on,
+// so we stop asking once it fails
+var rankMathAvailable = true
+
+// postSEO finds the SEO metadata for a post, copying any images it uses into dir
+func postSEO(p Post, dir string, sourceUrl *url.URL) *SEO {
+	var seo *SEO
+	if yoast, ok := p.Extra["yoast_head_json"].(map[string]interface{}); ok {
+		seo = seoFromYoast(yoast)
+	} else if head, ok := p.Extra["aioseo_head"].(string); ok && head != "" {
+		seo = seoFromHead(head)
+	} else if rankMathAvailable {
+		var result struct {
+			Success bool
+			Head    string
+		}
+		err := getJSON("rank_math/v1/getHead?url="+url.QueryEscape(p.Link), &result)
+		if err != nil {
+			rankMathAvailable = false
+		} else if result.Success && result.Head != "" {
+			seo = seoFromHead(result.Head)
+		}
+	}
+	if seo == nil || *seo == (SEO{}) {
+		return nil
+	}
+
+	if seo.Canonical != "" {
+		seo.Canonical = seoCanonical(seo.Canonical, p)
+	}
+	for _, img := range []*string{&seo.OGImage, &seo.TwitterImage} {
+		if *img == "" {
+			continue
+		}
+		asset := copyImage(*img, sourceUrl)
+		if asset != nil {
+			*img = fetchAsset(asset, dir)
+		}
+	}
+	return seo
+}
+
+// seoCanonical points a canonical url at the new site where we can, and
+// complains about those still pointing at the old one
+func seoCanonical(canonical string, p Post) string {
+	cu, err := url.Parse(canonical)
+	if err != nil || !cu.IsAbs() || !sameSite(cu) {
+		return canonical
+	}
+	if t := linkIndex.Lookup(cu); t != nil && t.Exported && siteUrl != "" {
+		return strings.TrimSuffix(siteUrl, "/") + t.Path
+	}
+	warn("Canonical url of %s points at the old site: %s", p.Link, canonical)
+	return canonical
+}
+
+func seoFromYoast(y map[string]interface{}) *SEO {
+	str := func(key string) string {
+		s, _ := y[key].(string)
+		return s
+	}
+	seo := &SEO{
+		Title:              str("title"),
+		Description:        str("description"),
+		Canonical:          str("canonical"),
+		OGTitle:            str("og_title"),
+		OGDescription:      str("og_description"),
+		TwitterCard:        str("twitter_card"),
+		TwitterTitle:       str("twitter_title"),
+		TwitterDescription: str("twitter_description"),
+		TwitterImage:       str("twitter_image"),
+	}
+	if robots, ok := y["robots"].(map[string]interface{}); ok {
+		seo.NoIndex = robots["index"] == "noindex"
+		seo.NoFollow = robots["follow"] == "nofollow"
+	}
+	if images, ok := y["og_image"].([]interface{}); ok && len(images) > 0 {
+		if img, ok := images[0].(map[string]interface{}); ok {
+			seo.OGImage, _ = img["url"].(string)
+		}
+	}
+	return seo
+}
+
+// seoFromHead picks the metadata out of the tags an SEO plugin would add to
+// the head of a page
+func seoFromHead(head string) *SEO {
+	tree, err := html.Parse(strings.NewReader(head))
+	if err != nil {
+		return nil
+	}
+	seo := &SEO{}
+	for _, n := range findElements(tree, "title", "meta", "link") {
+		switch n.Data {
+		case "title":
+			seo.Title = textContent(n)
+		case "link":
+			if strings.EqualFold(getAttr(n, "rel"), "canonical") {
+				seo.Canonical = getAttr(n, "href")
+			}
+		case "meta":
+			name := getAttr(n, "name")
+			if name == "" {
+				name = getAttr(n, "property")
+			}
+			content := getAttr(n, "content")
+			switch strings.ToLower(name) {
+			case "description":
+				seo.Description = content
+			case "robots":
+				for _, directive := range strings.Split(strings.ToLower(content), ",") {
+					switch strings.TrimSpace(directive) {
+					case "noindex":
+						seo.NoIndex = true
+					case "nofollow":
+						seo.NoFollow = true
+					}
+				}
+			case "og:title":
+				seo.OGTitle = content
+			case "og:description":
+				seo.OGDescription = content
+			case "og:image":
+				if seo.OGImage == "" {
+					seo.OGImage = content
+				}
+			case "twitter:card":
+				seo.TwitterCard = content
+			case "twitter:title":
+				seo.TwitterTitle = content
+			case "twitter:description":
+				seo.TwitterDescription = content
+			case "twitter:image":
+				seo.TwitterImage = content
+			}
+		}
+	}
+	return seo
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSeoFromYoast(t *testing.T) {
+	var yoast map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+		"title": "Post title - Site",
+		"description": "About the post",
+		"canonical": "https://example.com/2020/01/first/",
+		"robots": {"index": "noindex", "follow": "follow"},
+		"og_title": "OG title",
+		"og_description": "OG description",
+		"og_image": [{"url": "https://example.com/wp-content/uploads/og.jpg", "width": 1200}],
+		"twitter_card": "summary_large_image",
+		"twitter_image": "https://example.com/wp-content/uploads/tw.jpg",
+		"schema": {"@graph": []}
+	}`), &yoast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SEO{
+		Title:         "Post title - Site",
+		Description:   "About the post",
+		Canonical:     "https://example.com/2020/01/first/",
+		NoIndex:       true,
+		OGTitle:       "OG title",
+		OGDescription: "OG description",
+		OGImage:       "https://example.com/wp-content/uploads/og.jpg",
+		TwitterCard:   "summary_large_image",
+		TwitterImage:  "https://example.com/wp-content/uploads/tw.jpg",
+	}
+	if got := seoFromYoast(yoast); *got != want {
+		t.Errorf("seoFromYoast = %+v, want %+v", *got, want)
+	}
+	if got := seoFromYoast(map[string]interface{}{"og_image": "not a list"}); *got != (SEO{}) {
+		t.Errorf("seoFromYoast of nothing useful = %+v, want nothing", *got)
+	}
+}
+
+func TestSeoFromHead(t *testing.T) {
+	tests := []struct {
+		head string
+		want SEO
+	}{
+		{``, SEO{}},
+		{`<title>Post &amp; title</title>
+<meta name="description" content="About the post">
+<meta name="robots" content="NOINDEX, nofollow">
+<link rel="Canonical" href="https://example.com/2020/01/first/">
+<meta property="og:title" content="OG title">
+<meta property="og:image" content="first.jpg">
+<meta property="og:image" content="second.jpg">
+<meta name="twitter:card" content="summary">
+<meta name="twitter:title" content="Tw title">
+<meta name="twitter:description" content="Tw description">
+<meta name="twitter:image" content="tw.jpg">`, SEO{
+			Title:              "Post & title",
+			Description:        "About the post",
+			Canonical:          "https://example.com/2020/01/first/",
+			NoIndex:            true,
+			NoFollow:           true,
+			OGTitle:            "OG title",
+			OGImage:            "first.jpg",
+			TwitterCard:        "summary",
+			TwitterTitle:       "Tw title",
+			TwitterDescription: "Tw description",
+			TwitterImage:       "tw.jpg",
+		}},
+		{`<meta name="robots" content="index, follow"><link rel="stylesheet" href="style.css">`, SEO{}},
+	}
+	for _, tt := range tests {
+		if got := seoFromHead(tt.head); got == nil || *got != tt.want {
+			t.Errorf("seoFromHead(%q) = %+v, want %+v", tt.head, got, tt.want)
+		}
+	}
+}
+
+func TestSeoCanonical(t *testing.T) {
+	setFor(t, &linkIndex, testLinkIndex(t))
+	p := Post{Link: "https://example.com/2020/01/first/"}
+	tests := []struct {
+		siteUrl   string
+		canonical string
+		want      string
+	}{
+		{"https://new.example/", "https://example.com/2020/01/first/", "https://new.example/2020/01/first/"},
+		{"https://new.example", "https://example.com/?page_id=7", "https://new.example/about/"},
+		{"", "https://example.com/2020/01/first/", "https://example.com/2020/01/first/"},
+		{"https://new.example/", "https://example.com/2020/02/hidden/", "https://example.com/2020/02/hidden/"},
+		{"https://new.example/", "https://elsewhere.example/first/", "https://elsewhere.example/first/"},
+		{"https://new.example/", "/2020/01/first/", "/2020/01/first/"},
+	}
+	for _, tt := range tests {
+		setFor(t, &siteUrl, tt.siteUrl)
+		if got := seoCanonical(tt.canonical, p); got != tt.want {
+			t.Errorf("seoCanonical(%q) with site url %q = %q, want %q", tt.canonical, tt.siteUrl, got, tt.want)
+		}
+	}
+}
+
+func TestPostSEORankMath(t *testing.T) {
+	var requests atomic.Int32
+	testApi(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Query().Get("url") == "https://example.com/broken/" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"success": true,
+			"head":    `<meta name="description" content="From Rank Math">`,
+		})
+	})
+	setFor(t, &rankMathAvailable, true)
+	seo := postSEO(Post{Link: "https://example.com/first/"}, "", nil)
+	if seo == nil || seo.Description != "From Rank Math" {
+		t.Errorf("postSEO from Rank Math = %+v, want its description", seo)
+	}
+	if seo := postSEO(Post{Link: "https://example.com/broken/"}, "", nil); seo != nil {
+		t.Errorf("postSEO when Rank Math fails = %+v, want nothing", seo)
+	}
+	if seo := postSEO(Post{Link: "https://example.com/first/"}, "", nil); seo != nil || requests.Load() != 2 {
+		t.Errorf("postSEO kept asking Rank Math after it failed")
+	}
+	yoast := Post{Link: "https://example.com/first/", Extra: map[string]interface{}{
+		"yoast_head_json": map[string]interface{}{"title": "From Yoast"},
+	}}
+	if seo := postSEO(yoast, "", nil); seo == nil || seo.Title != "From Yoast" {
+		t.Errorf("postSEO from Yoast = %+v, want its title", seo)
+	}
+}
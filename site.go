@@ -0,0 +1,108 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// SiteIndex is what the root of the API tells us about the site
+type SiteIndex struct {
+	Name           string  `json:"name"`
+	Description    string  `json:"description"`
+	URL            string  `json:"url"`
+	Home           string  `json:"home"`
+	GmtOffset      float64 `json:"gmt_offset" mapstructure:"gmt_offset"`
+	TimezoneString string  `json:"timezone_string" mapstructure:"timezone_string"`
+	SiteIcon       int     `json:"-" mapstructure:"site_icon"`
+	SiteIconURL    string  `json:"-" mapstructure:"site_icon_url"`
+	SiteLogo       int     `json:"-" mapstructure:"site_logo"`
+
+	// Our copies of the icon and logo
+	Icon string `json:"icon,omitempty" mapstructure:"-"`
+	Logo string `json:"logo,omitempty" mapstructure:"-"`
+}
+
+// getSiteIndex reads the root of the API
+func getSiteIndex() *SiteIndex {
+	index := &SiteIndex{}
+	err := getJSON("", index)
+	if err != nil {
+		warn("Couldn't read the API index: %v", err)
+		return nil
+	}
+	return index
+}
+
+// HugoConfig is the part of a Hugo site config we can fill in
+type HugoConfig struct {
+	BaseURL  string `yaml:"baseURL,omitempty"`
+	Title    string `yaml:"title,omitempty"`
+	TimeZone string `yaml:"timeZone,omitempty"`
+	Params   struct {
+		Description string `yaml:"description,omitempty"`
+		Icon        string `yaml:"icon,omitempty"`
+		Logo        string `yaml:"logo,omitempty"`
+	} `yaml:"params"`
+}
+
+// writeSite saves the details of the site, its icon and logo, and for hugo
+// a fragment of config, so that the config of the new site needn't be
+// written by hand
+func writeSite(index *SiteIndex) {
+	if index == nil {
+		return
+	}
+	iconUrl := index.SiteIconURL
+	if iconUrl == "" && index.SiteIcon != 0 {
+		iconUrl = attachmentUrl(index.SiteIcon)
+	}
+	index.Icon = siteImage(iconUrl)
+	if index.SiteLogo != 0 {
+		index.Logo = siteImage(attachmentUrl(index.SiteLogo))
+	}
+	writeMeta("site", index)
+
+	if target.Name != "hugo" {
+		return
+	}
+	config := HugoConfig{
+		BaseURL:  siteUrl,
+		Title:    index.Name,
+		TimeZone: index.TimezoneString,
+	}
+	config.Params.Description = index.Description
+	config.Params.Icon = index.Icon
+	config.Params.Logo = index.Logo
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		fatal("Failed to encode site config: %v", err)
+	}
+	filename := filepath.Join(dest, "hugo-config.yaml")
+	err = writeFile(filename, data)
+	if err != nil {
+		fatal("Failed to write %s: %v", filename, err)
+	}
+}
+
+// siteImage copies the icon or logo of the site, returning its new url
+func siteImage(u string) string {
+	if u == "" {
+		return ""
+	}
+	sourceUrl, err := parseURL(apiUrl)
+	if err != nil {
+		return u
+	}
+	asset := copyImage(u, sourceUrl)
+	if asset == nil {
+		return u
+	}
+	filename := fetchAsset(asset, dest)
+	if strings.Contains(filename, "/") {
+		// We didn't copy it, so it's still a url or a path on the site
+		return filename
+	}
+	return sitePath + "/" + filename
+}
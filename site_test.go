@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteSite(t *testing.T) {
+	testApi(t, func(w http.ResponseWriter, r *http.Request) {
+		site := "http://" + r.Host
+		switch {
+		case r.URL.Path == "/wp-json/":
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"name":            "Example",
+				"description":     "Just another site",
+				"url":             site,
+				"home":            site,
+				"gmt_offset":      1,
+				"timezone_string": "Europe/London",
+				"site_icon":       10,
+				"site_icon_url":   site + "/wp-content/uploads/icon.png",
+				"site_logo":       11,
+			})
+		case r.URL.Path == "/wp-json/wp/v2/media":
+			_ = json.NewEncoder(w).Encode([]map[string]any{
+				{"id": 11, "source_url": site + "/wp-content/uploads/logo.png"},
+			})
+		case strings.HasPrefix(r.URL.Path, "/wp-content/uploads/"):
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte("png"))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	setFor(t, &dest, t.TempDir())
+	setFor(t, &sitePath, "/blog")
+	setFor(t, &siteUrl, "https://new.example/blog/")
+	setFor(t, &target, targets["hugo"])
+	setFor(t, &attachmentUrls, map[int]string{})
+	setFor(t, &fetchedAssets, map[string]string{})
+
+	index := getSiteIndex()
+	if index == nil || index.Name != "Example" || index.SiteIcon != 10 || index.SiteLogo != 11 {
+		t.Fatalf("getSiteIndex = %+v, want the site's details", index)
+	}
+	writeSite(index)
+
+	data, err := os.ReadFile(filepath.Join(dest, "site.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved map[string]any
+	err = json.Unmarshal(data, &saved)
+	if err != nil {
+		t.Fatalf("site.json isn't json: %v", err)
+	}
+	if saved["icon"] != "/blog/icon.png" || saved["logo"] != "/blog/logo.png" || saved["timezone_string"] != "Europe/London" {
+		t.Errorf("site.json = %v, want our copies of the icon and logo", saved)
+	}
+	if _, ok := saved["site_icon_url"]; ok {
+		t.Errorf("site.json has the old site's icon url")
+	}
+	for _, f := range []string{"icon.png", "logo.png"} {
+		if _, err := os.Stat(filepath.Join(dest, f)); err != nil {
+			t.Errorf("%s wasn't copied: %v", f, err)
+		}
+	}
+
+	config, err := os.ReadFile(filepath.Join(dest, "hugo-config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "baseURL: https://new.example/blog/\ntitle: Example\ntimeZone: Europe/London\nparams:\n" +
+		"  description: Just another site\n  icon: /blog/icon.png\n  logo: /blog/logo.png\n"
+	if string(config) != want {
+		t.Errorf("hugo-config.yaml =\n%s\nwant\n%s", config, want)
+	}
+}
+
+func TestSiteImage(t *testing.T) {
+	testApi(t, func(w http.ResponseWriter, r *http.Request) {})
+	site := strings.TrimSuffix(apiUrl, "/wp-json/")
+	setFor(t, &sitePath, "")
+	setFor(t, &mirror, false)
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"", ""},
+		{"https://elsewhere.example/icon.png", "https://elsewhere.example/icon.png"},
+		{site + "/favicon.png", "/favicon.png"},
+	}
+	for _, tt := range tests {
+		if got := siteImage(tt.url); got != tt.want {
+			t.Errorf("siteImage(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
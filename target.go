@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Target describes the conventions of the site generator we're exporting for
+type Target struct {
+	Name string
+	// Gallery returns the markup that replaces a gallery in the body of a post
+	Gallery func(g *Gallery) string
+	// IndexPage returns where to save the landing page for something in a
+	// section of the site, such as an author or category
+	IndexPage func(section string, slug string) string
+}
+
+func bundleIndex(section string, slug string) string {
+	return path.Join(section, slug, postFilename)
+}
+
+var targets = map[string]*Target{
+	"generic": {
+		Name:      "generic",
+		Gallery:   galleryHTML,
+		IndexPage: bundleIndex,
+	},
+	"hugo": {
+		Name: "hugo",
+		Gallery: func(g *Gallery) string {
+			return fmt.Sprintf(`{{< gallery id="%s" >}}`, g.ID)
+		},
+		IndexPage: func(section string, slug string) string {
+			return path.Join(section, slug, "_index.md")
+		},
+	},
+	"jekyll": {
+		Name: "jekyll",
+		Gallery: func(g *Gallery) string {
+			return fmt.Sprintf(`{%% include gallery.html id="%s" %%}`, g.ID)
+		},
+		IndexPage: func(section string, slug string) string {
+			return path.Join("_"+section, slug+".md")
+		},
+	},
+	"eleventy": {
+		Name: "eleventy",
+		Gallery: func(g *Gallery) string {
+			return fmt.Sprintf(`{%% gallery "%s" %%}`, g.ID)
+		},
+		IndexPage: func(section string, slug string) string {
+			return path.Join(section, slug, "index.md")
+		},
+	},
+	"gatsby": {
+		Name: "gatsby",
+		Gallery: func(g *Gallery) string {
+			return fmt.Sprintf(`<Gallery id="%s" />`, g.ID)
+		},
+		IndexPage: bundleIndex,
+	},
+}
+
+var target *Target
+
+func targetNames() string {
+	names := []string{}
+	for k := range targets {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// galleryHTML renders a gallery as plain HTML, pointing at the local copies
+// of the images
+func galleryHTML(g *Gallery) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<div class="gallery" id="%s">`, html.EscapeString(g.ID))
+	for _, img := range g.Images {
+		b.WriteString("<figure>")
+		href := img.Full
+		if img.Link != "" {
+			href = img.Link
+		}
+		if href != "" {
+			fmt.Fprintf(&b, `<a href="%s">`, html.EscapeString(href))
+		}
+		fmt.Fprintf(&b, `<img src="%s" alt="%s">`, html.EscapeString(img.File), html.EscapeString(img.Alt))
+		if href != "" {
+			b.WriteString("</a>")
+		}
+		if img.Caption != "" {
+			fmt.Fprintf(&b, "<figcaption>%s</figcaption>", html.EscapeString(img.Caption))
+		}
+		b.WriteString("</figure>")
+	}
+	if g.Caption != "" {
+		fmt.Fprintf(&b, "<p>%s</p>", html.EscapeString(g.Caption))
+	}
+	b.WriteString("</div>")
+	return b.String()
+}
+
+// writeIndexPage saves a landing page, such as for an author or category,
+// wherever our target expects it
+func writeIndexPage(section string, slug string, frontmatter interface{}, body string) {
+	filename := filepath.Join(dest, filepath.FromSlash(target.IndexPage(section, slug)))
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		postFailed("Failed to create directory for %s: %v", filename, err)
+		return
+	}
+	fm, err := yaml.Marshal(frontmatter)
+	if err != nil {
+		postFailed("Failed to encode frontmatter for %s: %v", filename, err)
+		return
+	}
+	content := "---\n" + string(fm) + "---\n" + body
+	if body != "" && !strings.HasSuffix(body, "\n") {
+		content += "\n"
+	}
+	err = writeFile(filename, []byte(content))
+	if err != nil {
+		postFailed("Failed to write %s: %v", filename, err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+type Taxonomy struct {
+	Name         string
+	Slug         string
+	RestBase     string `json:"rest_base" mapstructure:"rest_base"`
+	Hierarchical bool
+	Types        []string
+	Terms        map[int]*Term `mapstructure:"-"`
+}
+
+type Term struct {
+	ID          int
+	Name        string
+	Slug        string
+	Parent      int
+	Description string
+	Link        string
+}
+
+// Taxonomies that we handle elsewhere, or that aren't about content
+var builtinTaxonomies = []string{"category", "post_tag", "nav_menu", "link_category", "post_format",
+	"wp_theme", "wp_template_part_area", "wp_pattern_category", "author", "ppma_author"}
+
+// Custom taxonomies used by the posts and pages we're exporting
+var customTaxonomies []*Taxonomy
+
+// getTaxonomies discovers the custom taxonomies a site uses, and fetches
+// all their terms
+func getTaxonomies() []*Taxonomy {
+	all := map[string]*Taxonomy{}
+	err := getJSON("wp/v2/taxonomies?context=view", &all)
+	if err != nil {
+		warn("Failed to discover taxonomies: %v", err)
+		return nil
+	}
+	ret := []*Taxonomy{}
+	for _, slug := range slices.Sorted(maps.Keys(all)) {
+		t := all[slug]
+		if slices.Contains(builtinTaxonomies, slug) || t.RestBase == "" {
+			continue
+		}
+		if !slices.Contains(t.Types, "post") && !(exportPages && slices.Contains(t.Types, "page")) {
+			continue
+		}
+		t.Slug = slug
+		terms := []Term{}
+		err = fetchRoute(t.Name, &terms, "wp/v2/"+t.RestBase+"?context=view&_fields=id,name,slug,parent,description,link")
+		if err != nil {
+			warn("Failed to fetch %s: %v", t.Name, err)
+			continue
+		}
+		t.Terms = map[int]*Term{}
+		for idx, term := range terms {
+			t.Terms[term.ID] = &terms[idx]
+		}
+		ret = append(ret, t)
+	}
+	return ret
+}
+
+// The frontmatter key for a taxonomy
+func taxonomyKey(t *Taxonomy) string {
+	if key, ok := taxonomyKeys[t.Slug]; ok {
+		return key
+	}
+	return t.Slug
+}
+
+// The frontmatter key for the paths of the terms of a hierarchical taxonomy
+func taxonomyPathsKey(t *Taxonomy) string {
+	return taxonomyKey(t) + "Paths"
+}
+
+// postTerms resolves the custom taxonomy terms of a post to their names,
+// keyed by the frontmatter key of the taxonomy
+func postTerms(p Post) map[string]interface{} {
+	ret := map[string]interface{}{}
+	for _, t := range customTaxonomies {
+		raw, ok := p.Extra[t.RestBase].([]interface{})
+		if !ok || len(raw) == 0 {
+			continue
+		}
+		names := []string{}
+		paths := []string{}
+		for _, v := range raw {
+			id, ok := v.(float64)
+			if !ok {
+				continue
+			}
+			term, ok := t.Terms[int(id)]
+			if !ok {
+				warn("No such %s as %d in post %s", t.Name, int(id), p.Link)
+				continue
+			}
+			names = append(names, term.Name)
+			if t.Hierarchical {
+				paths = append(paths, termPath(t.Terms, term.ID))
+			}
+		}
+		ret[taxonomyKey(t)] = names
+		if t.Hierarchical {
+			// So that themes can render breadcrumbs
+			ret[taxonomyPathsKey(t)] = paths
+		}
+	}
+	return ret
+}
+
+// termPath is the slugs of a term and its ancestors, like news/local/sports
+func termPath(terms map[int]*Term, id int) string {
+	slugs := []string{}
+	for _, t := range termChain(terms, id, func(t *Term) int { return t.Parent }) {
+		slugs = append(slugs, t.Slug)
+	}
+	return strings.Join(slugs, "/")
+}
+
+// termChain is a term and its ancestors, starting from the top
+func termChain[T any](terms map[int]T, id int, parent func(T) int) []T {
+	chain := []T{}
+	seen := map[int]bool{}
+	for id != 0 && !seen[id] {
+		seen[id] = true
+		t, ok := terms[id]
+		if !ok {
+			break
+		}
+		chain = append([]T{t}, chain...)
+		id = parent(t)
+	}
+	return chain
+}
+
+// checkTaxonomyKeys makes sure we don't have two taxonomies writing to the
+// same key in the frontmatter
+func checkTaxonomyKeys() {
+	seen := map[string]string{}
+	for _, t := range customTaxonomies {
+		keys := []string{taxonomyKey(t)}
+		if t.Hierarchical {
+			keys = append(keys, taxonomyPathsKey(t))
+		}
+		for _, key := range keys {
+			if slices.Contains(reservedKeys, key) {
+				fatal("Can't save taxonomy %s as '%s', use --taxonomy-keys to map it to something else", t.Slug, key)
+			}
+			if other, ok := seen[key]; ok {
+				fatal("Taxonomies %s and %s would both be saved as '%s'", other, t.Slug, key)
+			}
+			seen[key] = t.Slug
+		}
+	}
+}
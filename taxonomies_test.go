@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+var testGenres = &Taxonomy{
+	Name:         "Genres",
+	Slug:         "genre",
+	RestBase:     "genres",
+	Hierarchical: true,
+	Terms: map[int]*Term{
+		1: {ID: 1, Name: "Fiction", Slug: "fiction"},
+		2: {ID: 2, Name: "Science Fiction", Slug: "sf", Parent: 1},
+		3: {ID: 3, Name: "Space Opera", Slug: "space-opera", Parent: 2},
+		4: {ID: 4, Name: "Loop", Slug: "loop", Parent: 5},
+		5: {ID: 5, Name: "Back", Slug: "back", Parent: 4},
+		6: {ID: 6, Name: "Orphan", Slug: "orphan", Parent: 99},
+	},
+}
+
+var testMoods = &Taxonomy{
+	Name:     "Moods",
+	Slug:     "mood",
+	RestBase: "moods",
+	Terms: map[int]*Term{
+		7: {ID: 7, Name: "Happy", Slug: "happy"},
+	},
+}
+
+func TestTermPath(t *testing.T) {
+	tests := []struct {
+		id   int
+		want string
+	}{
+		{1, "fiction"},
+		{3, "fiction/sf/space-opera"},
+		{4, "back/loop"},
+		{6, "orphan"},
+		{99, ""},
+	}
+	for _, tt := range tests {
+		if got := termPath(testGenres.Terms, tt.id); got != tt.want {
+			t.Errorf("termPath(%d) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestTaxonomyKey(t *testing.T) {
+	setFor(t, &taxonomyKeys, map[string]string{"genre": "genres"})
+	tests := []struct {
+		taxonomy *Taxonomy
+		key      string
+		paths    string
+	}{
+		{testGenres, "genres", "genresPaths"},
+		{testMoods, "mood", "moodPaths"},
+	}
+	for _, tt := range tests {
+		if got := taxonomyKey(tt.taxonomy); got != tt.key {
+			t.Errorf("taxonomyKey(%s) = %q, want %q", tt.taxonomy.Slug, got, tt.key)
+		}
+		if got := taxonomyPathsKey(tt.taxonomy); got != tt.paths {
+			t.Errorf("taxonomyPathsKey(%s) = %q, want %q", tt.taxonomy.Slug, got, tt.paths)
+		}
+	}
+}
+
+func TestPostTerms(t *testing.T) {
+	setFor(t, &taxonomyKeys, nil)
+	setFor(t, &customTaxonomies, []*Taxonomy{testGenres, testMoods})
+	tests := []struct {
+		extra map[string]interface{}
+		want  map[string]interface{}
+	}{
+		{nil, map[string]interface{}{}},
+		{map[string]interface{}{"genres": []interface{}{float64(3), float64(1)}, "moods": []interface{}{float64(7)}},
+			map[string]interface{}{
+				"genre":      []string{"Space Opera", "Fiction"},
+				"genrePaths": []string{"fiction/sf/space-opera", "fiction"},
+				"mood":       []string{"Happy"},
+			}},
+		{map[string]interface{}{"moods": []interface{}{float64(7), float64(42), "happy"}},
+			map[string]interface{}{"mood": []string{"Happy"}}},
+	}
+	for _, tt := range tests {
+		if got := postTerms(Post{Extra: tt.extra}); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("postTerms(%v) = %v, want %v", tt.extra, got, tt.want)
+		}
+	}
+}
+
+func TestGetTaxonomies(t *testing.T) {
+	testApi(t, func(w http.ResponseWriter, r *http.Request) {
+		var v any
+		switch r.URL.Path {
+		case "/wp-json/wp/v2/taxonomies":
+			v = map[string]any{
+				"category": map[string]any{"name": "Categories", "rest_base": "categories", "types": []string{"post"}},
+				"genre":    map[string]any{"name": "Genres", "rest_base": "genres", "hierarchical": true, "types": []string{"post"}},
+				"section":  map[string]any{"name": "Sections", "rest_base": "sections", "types": []string{"page"}},
+				"private":  map[string]any{"name": "Private", "rest_base": "", "types": []string{"post"}},
+			}
+		case "/wp-json/wp/v2/genres":
+			v = []map[string]any{{"id": 1, "name": "Fiction", "slug": "fiction"}}
+		case "/wp-json/wp/v2/sections":
+			v = []map[string]any{{"id": 2, "name": "Help", "slug": "help"}}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(v)
+	})
+	tests := []struct {
+		pages bool
+		want  []string
+	}{
+		{false, []string{"genre"}},
+		{true, []string{"genre", "section"}},
+	}
+	for _, tt := range tests {
+		setFor(t, &exportPages, tt.pages)
+		got := []string{}
+		for _, taxonomy := range getTaxonomies() {
+			got = append(got, taxonomy.Slug)
+			if len(taxonomy.Terms) != 1 {
+				t.Errorf("taxonomy %s has %d terms, want 1", taxonomy.Slug, len(taxonomy.Terms))
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("getTaxonomies with pages %v = %v, want %v", tt.pages, got, tt.want)
+		}
+	}
+}